@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/kuberlab/pacak/pkg/backup"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// runBackup implements 'pacak backup' command writing bundles of all
+// repositories into a new directory under -target.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	gitPath := fs.String("git-data-path", "/pacak-data", "Path of bare git repos")
+	target := fs.String("target", "", "Directory backups are written to")
+	incremental := fs.Bool("incremental", false, "Bundle only commits added since the latest backup in target")
+	fs.Parse(args)
+	if *target == "" {
+		logrus.Fatal("-target is required")
+	}
+	// Local copies are not used by backup.
+	git := pacakimpl.NewGitInterface(*gitPath, os.TempDir())
+	m, dir, err := backup.Run(git, *target, *incremental)
+	if err != nil {
+		logrus.Fatalf("Backup failed: %v", err)
+	}
+	logrus.Infof("Backup of %d repositories written to %v", len(m.Repositories), dir)
+	if failed := m.Failed(); failed > 0 {
+		logrus.Errorf("Backup of %d repositories failed, see %v/manifest.json", failed, dir)
+		os.Exit(1)
+	}
+}
@@ -12,4 +12,7 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v2 v2.4.0
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 )
@@ -5,12 +5,92 @@ import (
 	"github.com/kuberlab/pacak/pkg/pacakimpl"
 	"os"
 	"path"
+	"time"
 	"github.com/kuberlab/pacak/pkg/api"
+	"github.com/kuberlab/pacak/pkg/events"
+	"github.com/kuberlab/pacak/pkg/index"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/kuberlab/pacak/pkg/maintenance"
+	"github.com/kuberlab/pacak/pkg/mirror"
+	"github.com/kuberlab/pacak/pkg/webhook"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		runBackup(os.Args[2:])
+		return
+	}
 	gitPath := flag.String("git-data-path", "/pacak-data", "Path to store bare git repos")
 	localPath := flag.String("local-data-path", path.Join(os.TempDir(), "pacak-work-data"), "Path for local copy git directory. Used for commits")
+	webdavDelay := flag.Duration("webdav-commit-delay", 5*time.Second, "Idle time after which writes of a WebDAV client are committed")
+	s3Address := flag.String("s3-address", "", "Listen address of read-only S3 gateway, e.g. ':8083'. Disabled if empty")
+	indexPath := flag.String("index-path", "", "Path of commit search index file. Default is '.pacak/index.db' under git-data-path")
+	validationConfig := flag.String("validation-config", "", "Path of JSON file with validation rules checked before changes are pushed")
+	templatesPath := flag.String("templates-path", "", "Path of repository templates. Each subdirectory or git repository is a template")
+	importPath := flag.String("import-path", "", "Directory repositories can be imported from. Import from paths is disabled if empty")
+	mirrorCheck := flag.Duration("mirror-check-interval", time.Minute, "How often mirrors are checked for pending sync")
+	jobWorkers := flag.Int("job-workers", 4, "Number of background jobs run at once")
+	maintenanceInterval := flag.Duration("maintenance-interval", time.Hour, "How often repositories are checked for needed maintenance. Disabled if 0")
+	gcLooseObjects := flag.Int("gc-loose-objects", 1000, "Number of loose objects git gc runs at")
+	gcPacks := flag.Int("gc-packs", 50, "Number of packs objects are repacked into a single pack at")
+	fsckInterval := flag.Duration("fsck-interval", 7*24*time.Hour, "Time between git fsck runs of a repository. Disabled if 0")
+	quotaConfig := flag.String("quota-config", "", "Path of JSON file with disk quotas of repositories and namespaces")
+	flag.Parse()
 	git := pacakimpl.NewGitInterface(*gitPath, *localPath)
-	api.StartAPI(git)
+	git.SetTemplatesPath(*templatesPath)
+	if *validationConfig != "" {
+		rules, err := pacakimpl.LoadValidationRules(*validationConfig)
+		if err == nil {
+			err = git.SetValidationRules(rules)
+		}
+		if err != nil {
+			logrus.Fatalf("Failed load validation rules %v: %v", *validationConfig, err)
+		}
+	}
+	if *quotaConfig != "" {
+		quotas, err := pacakimpl.LoadQuotas(*quotaConfig)
+		if err == nil {
+			err = git.SetQuotas(quotas)
+		}
+		if err != nil {
+			logrus.Fatalf("Failed load quotas %v: %v", *quotaConfig, err)
+		}
+	}
+	metaPath := path.Join(*gitPath, ".pacak")
+	if *indexPath == "" {
+		*indexPath = path.Join(metaPath, "index.db")
+	}
+	idx, err := index.Open(*indexPath)
+	if err != nil {
+		logrus.Fatalf("Failed open commit index %v: %v", *indexPath, err)
+	}
+	hooks, err := webhook.NewManager(path.Join(metaPath, "webhooks"), git)
+	if err != nil {
+		logrus.Fatalf("Failed load webhooks: %v", err)
+	}
+	broker, err := events.NewBroker(path.Join(metaPath, "events.log"), 1000, git)
+	if err != nil {
+		logrus.Fatalf("Failed load events: %v", err)
+	}
+	jobManager, err := jobs.NewManager(path.Join(metaPath, "jobs"), *jobWorkers)
+	if err != nil {
+		logrus.Fatalf("Failed load jobs: %v", err)
+	}
+	api.StartAPI(git, api.Config{
+		WebDAVCommitDelay: *webdavDelay,
+		S3Address:         *s3Address,
+		Index:             index.NewIndexer(idx, git),
+		Webhooks:          hooks,
+		Events:            broker,
+		ImportPath:        *importPath,
+		Jobs:              jobManager,
+		Maintenance: maintenance.NewScheduler(git, jobManager, maintenance.Policy{
+			LooseObjects: *gcLooseObjects,
+			Packs:        *gcPacks,
+			FsckInterval: *fsckInterval,
+		}, *maintenanceInterval),
+		Mirrors:           mirror.NewScheduler(git, jobManager, *mirrorCheck),
+		PushMirrors:       mirror.NewPusher(git),
+	})
 }
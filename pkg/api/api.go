@@ -14,14 +14,55 @@ import (
 	"github.com/emicklei/go-restful"
 	git "github.com/gogits/git-module"
 	"github.com/gorilla/mux"
+	"github.com/kuberlab/pacak/pkg/davfs"
+	"github.com/kuberlab/pacak/pkg/events"
+	"github.com/kuberlab/pacak/pkg/index"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/kuberlab/pacak/pkg/maintenance"
+	"github.com/kuberlab/pacak/pkg/mirror"
 	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/kuberlab/pacak/pkg/webhook"
 )
 
 type pacakAPI struct {
-	git pacakimpl.GitInterface
+	git     pacakimpl.GitInterface
+	dav     *davfs.Store
+	index   *index.Indexer
+	hooks   *webhook.Manager
+	events  *events.Broker
+	mirrors *mirror.Scheduler
+	pushers *mirror.Pusher
+	jobs    *jobs.Manager
+
+	maintenance *maintenance.Scheduler
+
+	importPath string
+}
+
+type Config struct {
+	// WebDAVCommitDelay is idle time after which writes of a WebDAV client are committed.
+	WebDAVCommitDelay time.Duration
+	// S3Address is listen address of S3 gateway. Gateway is disabled if empty.
+	S3Address string
+	// Index is the commit index used for search across repositories. Search is disabled if nil.
+	Index *index.Indexer
+	// Webhooks delivers repository events to subscribed hooks.
+	Webhooks *webhook.Manager
+	// Events streams ref updates to clients.
+	Events *events.Broker
+	// Mirrors syncs pull mirrors.
+	Mirrors *mirror.Scheduler
+	// PushMirrors pushes changed refs to push mirrors.
+	PushMirrors *mirror.Pusher
+	// Jobs runs long operations in background. It is started by StartAPI.
+	Jobs *jobs.Manager
+	// Maintenance runs gc, repack and fsck on repositories.
+	Maintenance *maintenance.Scheduler
+	// ImportPath is the directory repositories are imported from. Import from paths is disabled if empty.
+	ImportPath string
 }
 
-func StartAPI(git pacakimpl.GitInterface) {
+func StartAPI(git pacakimpl.GitInterface, config Config) {
 	r := mux.NewRouter()
 	r.NotFoundHandler = NotFoundHandler()
 	container := restful.NewContainer()
@@ -32,13 +73,86 @@ func StartAPI(git pacakimpl.GitInterface) {
 	ws.Produces(restful.MIME_JSON)
 
 	api := pacakAPI{
-		git: git,
+		git:     git,
+		dav:     davfs.NewStore(config.WebDAVCommitDelay),
+		index:   config.Index,
+		hooks:   config.Webhooks,
+		events:  config.Events,
+		mirrors: config.Mirrors,
+		pushers: config.PushMirrors,
+		jobs:    config.Jobs,
+
+		maintenance: config.Maintenance,
+
+		importPath: config.ImportPath,
 	}
+	api.jobs.Register(importJob, api.runImport)
+	api.jobs.Start()
 	ws.Route(ws.POST("/git/init/{repo}").To(api.Init))
+	ws.Route(ws.POST("/git/fork/{repo}").To(api.Fork))
+	ws.Route(ws.GET("/git/bundle/{repo}").To(api.Bundle).
+		Produces("application/x-git-bundle", restful.MIME_JSON))
+	ws.Route(ws.POST("/git/import/{repo}").To(api.Import).
+		Consumes(restful.MIME_JSON, "application/x-git-bundle"))
+	ws.Route(ws.POST("/imports").To(api.BulkImport))
+	ws.Route(ws.GET("/jobs").To(api.Jobs))
+	ws.Route(ws.GET("/jobs/{id}").To(api.Job))
+	ws.Route(ws.POST("/jobs/{id}/cancel").To(api.CancelJob))
+	ws.Route(ws.GET("/admin/quotas").To(api.Quotas))
+	ws.Route(ws.GET("/admin/usage").To(api.Usage))
+	ws.Route(ws.GET("/git/usage/{repo}").To(api.RepoUsage))
+	ws.Route(ws.GET("/admin/maintenance").To(api.Maintenance))
+	ws.Route(ws.GET("/admin/maintenance/{repo}").To(api.RepoMaintenance))
+	ws.Route(ws.POST("/admin/maintenance/{repo}").To(api.Maintain))
 	ws.Route(ws.POST("/git/commit/{repo}/{file}").To(api.Commit))
 	ws.Route(ws.GET("/git/commits/{repo}").To(api.Commits))
+	ws.Route(ws.POST("/git/merge/{repo}").To(api.Merge))
+	ws.Route(ws.POST("/git/revert/{repo}").To(api.Revert))
+	ws.Route(ws.POST("/git/cherry-pick/{repo}").To(api.CherryPick))
+	ws.Route(ws.POST("/git/reset/{repo}").To(api.Reset))
+	ws.Route(ws.POST("/git/apply/{repo}/{branch}").To(api.ApplyPatch))
+	ws.Route(ws.GET("/git/patch/{repo}/{rev}").To(api.Patch).
+		Produces("text/x-patch", "application/mbox", restful.MIME_JSON))
+	ws.Route(ws.GET("/git/blame/{repo}/{rev}/{path:*}").To(api.Blame))
+	ws.Route(ws.GET("/git/history/{repo}/{rev}/{path:*}").To(api.FileHistory))
+	ws.Route(ws.GET("/git/search/{repo}/{rev}").To(api.Search))
+	ws.Route(ws.GET("/search/commits").To(api.SearchCommits))
+	ws.Route(ws.GET("/events").To(api.Events).Produces("text/event-stream"))
+	ws.Route(ws.GET("/validation-rules").To(api.ValidationRules))
+	ws.Route(ws.GET("/templates").To(api.Templates))
+	ws.Route(ws.GET("/webhooks").To(api.Webhooks))
+	ws.Route(ws.POST("/webhooks").To(api.AddWebhook))
+	ws.Route(ws.GET("/webhooks/{id}").To(api.Webhook))
+	ws.Route(ws.DELETE("/webhooks/{id}").To(api.DeleteWebhook))
+	ws.Route(ws.GET("/webhooks/{id}/deliveries").To(api.WebhookDeliveries))
+	ws.Route(ws.GET("/git/merge-requests/{repo}").To(api.MergeRequests))
+	ws.Route(ws.POST("/git/merge-requests/{repo}").To(api.CreateMergeRequest))
+	ws.Route(ws.GET("/git/merge-requests/{repo}/{id}").To(api.MergeRequest))
+	ws.Route(ws.GET("/git/merge-requests/{repo}/{id}/status").To(api.MergeRequestStatus))
+	ws.Route(ws.GET("/git/merge-requests/{repo}/{id}/diff").To(api.MergeRequestDiff).
+		Produces("text/x-patch", restful.MIME_JSON))
+	ws.Route(ws.POST("/git/merge-requests/{repo}/{id}/comments").To(api.CommentMergeRequest))
+	ws.Route(ws.POST("/git/merge-requests/{repo}/{id}/approve").To(api.ApproveMergeRequest))
+	ws.Route(ws.POST("/git/merge-requests/{repo}/{id}/close").To(api.CloseMergeRequest))
+	ws.Route(ws.POST("/git/merge-requests/{repo}/{id}/merge").To(api.MergeMergeRequest))
+	ws.Route(ws.GET("/git/protection/{repo}").To(api.Protection))
+	ws.Route(ws.PUT("/git/protection/{repo}").To(api.SetProtection))
+	ws.Route(ws.GET("/git/mirror/{repo}").To(api.Mirror))
+	ws.Route(ws.PUT("/git/mirror/{repo}").To(api.SetMirror))
+	ws.Route(ws.DELETE("/git/mirror/{repo}").To(api.DeleteMirror))
+	ws.Route(ws.POST("/git/mirror/{repo}/sync").To(api.SyncMirror))
+	ws.Route(ws.GET("/git/push-mirrors/{repo}").To(api.PushMirrors))
+	ws.Route(ws.POST("/git/push-mirrors/{repo}").To(api.AddPushMirror))
+	ws.Route(ws.DELETE("/git/push-mirrors/{repo}/{id}").To(api.DeletePushMirror))
+	ws.Route(ws.POST("/git/push-mirrors/{repo}/{id}/sync").To(api.SyncPushMirror))
+	ws.Route(ws.GET("/git/reflog/{repo}").To(api.RefLog))
+	ws.Route(ws.POST("/git/reflog/{repo}/{id}/undo").To(api.UndoRefUpdate))
 	container.Add(ws)
+	if config.S3Address != "" {
+		go startS3(api, config.S3Address)
+	}
 	r.PathPrefix("/api/v1/").Handler(container)
+	r.PathPrefix("/webdav/{repo}/{branch}").HandlerFunc(api.WebDAV)
 	logrus.Infoln("Listen in *:8082")
 	if err := http.ListenAndServe(":8082", WrapLogger(r)); err != nil {
 		logrus.Errorln(err)
@@ -49,12 +163,8 @@ func StartAPI(git pacakimpl.GitInterface) {
 func (api pacakAPI) Init(req *restful.Request, resp *restful.Response) {
 	repo := "test/" + req.PathParameter("repo")
 
-	logrus.Infoln("Init: %v", repo)
-	if err := api.git.InitRepository(Signature(req), repo, nil); err != nil {
-		resp.WriteError(http.StatusInternalServerError, err)
-	} else {
-		resp.WriteHeader(http.StatusNoContent)
-	}
+	logrus.Infof("Init: %v", repo)
+	api.initRepository(req, resp, repo)
 }
 
 func (api pacakAPI) Commits(req *restful.Request, resp *restful.Response) {
@@ -127,11 +237,15 @@ func NotFoundHandler() http.Handler {
 }
 
 func Signature(req *restful.Request) git.Signature {
-	email := req.HeaderParameter("GIT_EMAIL")
+	return signature(req.Request)
+}
+
+func signature(req *http.Request) git.Signature {
+	email := req.Header.Get("GIT_EMAIL")
 	if email == "" {
 		email = "pacak@kuberlab.com"
 	}
-	name := req.HeaderParameter("GIT_NAME")
+	name := req.Header.Get("GIT_NAME")
 	if name == "" {
 		name = "pacak"
 	}
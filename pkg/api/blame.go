@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+)
+
+// Blame returns per-line authorship of '{path}' at '{rev}'.
+// Optional 'from' and 'to' query parameters limit the line range.
+func (api pacakAPI) Blame(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	lines := []int{0, 0}
+	for i, name := range []string{"from", "to"} {
+		if v := req.QueryParameter(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				resp.WriteErrorString(http.StatusBadRequest, "Invalid line number "+name+"="+v)
+				return
+			}
+			lines[i] = n
+		}
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	blame, err := gitRepo.Blame(req.PathParameter("rev"), req.PathParameter("path"), lines[0], lines[1])
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(blame)
+}
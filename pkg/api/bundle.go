@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// bundleWriter sets bundle headers on the first write, so errors
+// happened before any data is written are reported as usual.
+type bundleWriter struct {
+	resp    *restful.Response
+	name    string
+	started bool
+}
+
+func (w *bundleWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.resp.Header().Set("Content-Type", "application/x-git-bundle")
+		w.resp.Header().Set("Content-Disposition", "attachment; filename=\""+w.name+".bundle\"")
+		w.resp.WriteHeader(http.StatusOK)
+	}
+	return w.resp.Write(b)
+}
+
+// Bundle writes git bundle of '{repo}' with all branches and tags.
+// Bundle is incremental if 'since' commit ids or refs are given, one per
+// parameter; 204 is returned if nothing changed since them.
+func (api pacakAPI) Bundle(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	w := &bundleWriter{resp: resp, name: path.Base(repo)}
+	_, err = gitRepo.WriteBundle(w, req.Request.URL.Query()["since"])
+	switch {
+	case errors.IsEmptyBundle(err):
+		resp.WriteHeader(http.StatusNoContent)
+	case err != nil && !w.started:
+		writeError(resp, err)
+	case err != nil:
+		logrus.Errorf("Failed write bundle of %v: %v", repo, err)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/index"
+)
+
+const maxCommitSearchLimit = 1000
+
+// SearchCommits searches commits of all repositories by message, author,
+// tags, changed paths and commit ID. Query parameters: q (all words must
+// match), repo (full repository name or namespace ending with '/') and limit.
+func (api pacakAPI) SearchCommits(req *restful.Request, resp *restful.Response) {
+	if api.index == nil {
+		resp.WriteErrorString(http.StatusServiceUnavailable, "Commit index is disabled")
+		return
+	}
+	opts := index.SearchOptions{
+		Repo:  req.QueryParameter("repo"),
+		Limit: 50,
+	}
+	if s := req.QueryParameter("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			resp.WriteErrorString(http.StatusBadRequest, "Invalid limit="+s)
+			return
+		}
+		opts.Limit = n
+	}
+	if opts.Limit > maxCommitSearchLimit {
+		opts.Limit = maxCommitSearchLimit
+	}
+	q := req.QueryParameter("q")
+	if q == "" {
+		resp.WriteErrorString(http.StatusBadRequest, "Query parameter 'q' is required")
+		return
+	}
+	res, err := api.index.Search(q, opts)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	resp.WriteEntity(res)
+}
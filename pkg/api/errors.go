@@ -1,7 +1,110 @@
 package api
 
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
 type APIError struct {
-	Status  int    `json:"status"`
-	Message string `json:"message,omitempty"`
-	Reason  string `json:"reason,omitempty"`
+	Status     int                `json:"status"`
+	Message    string             `json:"message,omitempty"`
+	Reason     string             `json:"reason,omitempty"`
+	Conflicts  []errors.Conflict  `json:"conflicts,omitempty"`
+	Violations []errors.Violation `json:"violations,omitempty"`
+}
+
+// writeError writes err as APIError choosing status by the error type.
+func writeError(resp *restful.Response, err error) {
+	apiErr := APIError{
+		Status:  http.StatusInternalServerError,
+		Message: err.Error(),
+	}
+	switch e := err.(type) {
+	case errors.RepositoryNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "RepositoryNotFound"
+	case errors.RepositoryAlreadyExists:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "RepositoryAlreadyExists"
+	case errors.RepositoryNotEmpty:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "RepositoryNotEmpty"
+	case errors.BranchNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "BranchNotFound"
+	case errors.RevisionNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "RevisionNotFound"
+	case errors.PathNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "PathNotFound"
+	case errors.RefUpdateNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "RefUpdateNotFound"
+	case errors.RefChanged:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "RefChanged"
+	case errors.PatchFailed:
+		apiErr.Status = http.StatusUnprocessableEntity
+		apiErr.Reason = "PatchFailed"
+	case errors.BranchAlreadyExists:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "BranchAlreadyExists"
+	case errors.NotFastForward:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "NotFastForward"
+	case errors.AlreadyUpToDate:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "AlreadyUpToDate"
+	case errors.MergeConflict:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "MergeConflict"
+		apiErr.Conflicts = e.Conflicts
+	case errors.QuotaExceeded:
+		apiErr.Status = http.StatusInsufficientStorage
+		apiErr.Reason = "QuotaExceeded"
+	case errors.JobNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "JobNotFound"
+	case errors.JobFinished:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "JobFinished"
+	case errors.JobConflict:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "JobConflict"
+	case errors.PushMirrorNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "PushMirrorNotFound"
+	case errors.InvalidSearchQuery:
+		apiErr.Status = http.StatusBadRequest
+		apiErr.Reason = "InvalidSearchQuery"
+	case errors.InvalidTemplatePath:
+		apiErr.Status = http.StatusBadRequest
+		apiErr.Reason = "InvalidTemplatePath"
+	case errors.TemplateNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "TemplateNotFound"
+	case errors.MergeRequestNotFound:
+		apiErr.Status = http.StatusNotFound
+		apiErr.Reason = "MergeRequestNotFound"
+	case errors.MergeRequestNotOpen:
+		apiErr.Status = http.StatusConflict
+		apiErr.Reason = "MergeRequestNotOpen"
+	case errors.NotEnoughApprovals:
+		apiErr.Status = http.StatusForbidden
+		apiErr.Reason = "NotEnoughApprovals"
+	case errors.SelfApproval:
+		apiErr.Status = http.StatusForbidden
+		apiErr.Reason = "SelfApproval"
+	case errors.ProtectedRef:
+		apiErr.Status = http.StatusForbidden
+		apiErr.Reason = "ProtectedRef"
+	case errors.ValidationFailed:
+		apiErr.Status = http.StatusUnprocessableEntity
+		apiErr.Reason = "ValidationFailed"
+		apiErr.Violations = e.Violations
+	}
+	resp.WriteHeaderAndEntity(apiErr.Status, apiErr)
 }
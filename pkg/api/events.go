@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/events"
+)
+
+// eventsHeartbeat is the interval of comments keeping idle streams alive.
+const eventsHeartbeat = 30 * time.Second
+
+// Events streams ref updates as Server-Sent Events. Query parameters repo
+// (repository or namespace ending with '/') and ref (name or pattern)
+// filter events. Stream is resumed after the ID in Last-Event-ID header
+// or 'since' parameter. If some events after it are not kept anymore,
+// 'reset' event is sent first and the client should reload its state.
+func (api pacakAPI) Events(req *restful.Request, resp *restful.Response) {
+	flusher, ok := resp.ResponseWriter.(http.Flusher)
+	if !ok {
+		resp.WriteErrorString(http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	after := int64(-1)
+	since := req.HeaderParameter("Last-Event-ID")
+	if since == "" {
+		since = req.QueryParameter("since")
+	}
+	if since != "" {
+		v, err := strconv.ParseInt(since, 10, 64)
+		if err != nil || v < 0 {
+			resp.WriteErrorString(http.StatusBadRequest, "Invalid event ID "+since)
+			return
+		}
+		after = v
+	}
+	filter := events.Filter{
+		Repo: req.QueryParameter("repo"),
+		Ref:  req.QueryParameter("ref"),
+	}
+	backlog, complete, ch, cancel := api.events.Subscribe(after, filter)
+	defer cancel()
+
+	header := resp.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	if !complete {
+		fmt.Fprint(resp, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range backlog {
+		if err := writeEvent(resp, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(resp, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				// Client is too slow, it reconnects and resumes from the last ID.
+				return
+			}
+			if err := writeEvent(resp, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(resp *restful.Response, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(resp, "id: %d\nevent: ref-update\ndata: %s\n\n", e.ID, data)
+	return err
+}
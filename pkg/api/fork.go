@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+type forkOptions struct {
+	// Name is the name of the new repository.
+	Name string `json:"name"`
+}
+
+// Fork creates a repository sharing objects with '{repo}'.
+func (api pacakAPI) Fork(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := forkOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if !validRepoName(opts.Name) {
+		resp.WriteErrorString(http.StatusBadRequest, "Invalid fork name '"+opts.Name+"'")
+		return
+	}
+	logrus.Infof("Fork: %v => test/%v", repo, opts.Name)
+	if err := api.git.Fork(Signature(req), repo, "test/"+opts.Name); err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusCreated)
+}
+
+// validRepoName reports whether name can be used as a repository name in the namespace.
+func validRepoName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
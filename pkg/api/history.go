@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+)
+
+// FileHistory returns every version of '{path}' reachable from '{rev}', following renames.
+func (api pacakAPI) FileHistory(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	limit := 0
+	if v := req.QueryParameter("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	history, err := gitRepo.FileHistory(req.PathParameter("rev"), req.PathParameter("path"), limit)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(history)
+}
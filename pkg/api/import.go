@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/sirupsen/logrus"
+)
+
+// importJob is the type of repository import jobs.
+const importJob = "import"
+
+type importOptions struct {
+	// Repo is the name of the new repository, used by bulk import only.
+	Repo string `json:"repo,omitempty"`
+	// Path of git repository, git bundle or directory relative to import path.
+	Path string `json:"path"`
+}
+
+type importParams struct {
+	// Source is the path given by client or "bundle" for uploads.
+	Source    string        `json:"source"`
+	Path      string        `json:"path"`
+	Committer git.Signature `json:"committer"`
+}
+
+// sourcePath resolves p within import path. Symlinks are resolved, so
+// they can not point outside of import path.
+func (api pacakAPI) sourcePath(p string) (string, error) {
+	if api.importPath == "" {
+		return "", fmt.Errorf("Import from path is disabled, set -import-path")
+	}
+	root, err := filepath.EvalSymlinks(api.importPath)
+	if err != nil {
+		return "", err
+	}
+	src, err := filepath.EvalSymlinks(filepath.Join(api.importPath, filepath.FromSlash(p)))
+	if err != nil {
+		return "", fmt.Errorf("Path '%s' does not exist", p)
+	}
+	if rel, err := filepath.Rel(root, src); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Path '%s' is outside of import path", p)
+	}
+	return src, nil
+}
+
+func (api pacakAPI) runImport(ctx *jobs.Context, data json.RawMessage) (interface{}, error) {
+	params := importParams{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	repo := ctx.Job().Repo
+	logrus.Infof("Import: %v => %v", params.Source, repo)
+	ctx.SetProgress(0, "importing "+params.Source)
+	return nil, api.git.ImportRepository(ctx, params.Committer, repo, params.Path)
+}
+
+// Import creates '{repo}' from a git bundle sent as the body with
+// Content-Type application/x-git-bundle, or from a path under the import
+// path given as JSON {"path": "..."}. Import runs as a job which is returned.
+func (api pacakAPI) Import(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("repo")
+	repo := "test/" + name
+	if !validRepoName(name) {
+		resp.WriteErrorString(http.StatusBadRequest, "Invalid repository name '"+name+"'")
+		return
+	}
+	if api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryAlreadyExists{Name: repo})
+		return
+	}
+	params := importParams{Committer: Signature(req)}
+	var files []string
+	if strings.HasPrefix(req.HeaderParameter("Content-Type"), "application/x-git-bundle") {
+		src, err := api.saveBundle(req.Request.Body)
+		if err != nil {
+			resp.WriteError(http.StatusInternalServerError, err)
+			return
+		}
+		params.Source, params.Path = "bundle", src
+		files = append(files, src)
+	} else {
+		opts := importOptions{}
+		if err := req.ReadEntity(&opts); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		src, err := api.sourcePath(opts.Path)
+		if err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		params.Source, params.Path = opts.Path, src
+	}
+	job, err := api.jobs.Submit(importJob, repo, params, files...)
+	if err != nil {
+		for _, f := range files {
+			os.Remove(f)
+		}
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusAccepted, job)
+}
+
+type bulkImportResult struct {
+	Repo  string    `json:"repo"`
+	Job   *jobs.Job `json:"job,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// BulkImport queues import job for each repository of the list from the
+// import path. Invalid entries are reported without stopping others.
+func (api pacakAPI) BulkImport(req *restful.Request, resp *restful.Response) {
+	opts := []importOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	res := make([]bulkImportResult, 0, len(opts))
+	for _, o := range opts {
+		repo := "test/" + o.Repo
+		src, err := api.sourcePath(o.Path)
+		if err == nil && !validRepoName(o.Repo) {
+			err = fmt.Errorf("Invalid repository name '%s'", o.Repo)
+		}
+		if err == nil && api.git.ExistsRepository(repo) {
+			err = errors.RepositoryAlreadyExists{Name: repo}
+		}
+		var job jobs.Job
+		if err == nil {
+			job, err = api.jobs.Submit(importJob, repo, importParams{
+				Source:    o.Path,
+				Path:      src,
+				Committer: Signature(req),
+			})
+		}
+		if err != nil {
+			res = append(res, bulkImportResult{Repo: repo, Error: err.Error()})
+			continue
+		}
+		res = append(res, bulkImportResult{Repo: repo, Job: &job})
+	}
+	resp.WriteHeaderAndEntity(http.StatusAccepted, res)
+}
+
+// saveBundle writes uploaded bundle next to jobs, so it survives restarts.
+func (api pacakAPI) saveBundle(body io.Reader) (string, error) {
+	dir := path.Join(api.jobs.Dir(), "uploads")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, "import-*.bundle")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
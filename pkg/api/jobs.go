@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/kuberlab/pacak/pkg/process"
+)
+
+type jobProcess struct {
+	PID         int64     `json:"pid"`
+	Description string    `json:"description"`
+	Start       time.Time `json:"start"`
+}
+
+type jobInfo struct {
+	jobs.Job
+	// Processes are git commands currently run by the job.
+	Processes []jobProcess `json:"processes"`
+}
+
+// Jobs returns jobs filtered by optional 'type', 'repo' and 'state', newest first.
+func (api pacakAPI) Jobs(req *restful.Request, resp *restful.Response) {
+	repo := req.QueryParameter("repo")
+	if repo != "" {
+		repo = "test/" + repo
+	}
+	resp.WriteEntity(api.jobs.List(
+		req.QueryParameter("type"),
+		repo,
+		jobs.State(req.QueryParameter("state")),
+	))
+}
+
+// Job returns the job with git commands it is running.
+func (api pacakAPI) Job(req *restful.Request, resp *restful.Response) {
+	job, err := api.jobs.Get(req.PathParameter("id"))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	info := jobInfo{Job: job, Processes: []jobProcess{}}
+	for _, p := range process.List(job.ID) {
+		info.Processes = append(info.Processes, jobProcess{
+			PID:         p.PID,
+			Description: p.Description,
+			Start:       p.Start,
+		})
+	}
+	resp.WriteEntity(info)
+}
+
+// CancelJob cancels queued or running job killing its git commands.
+func (api pacakAPI) CancelJob(req *restful.Request, resp *restful.Response) {
+	job, err := api.jobs.Cancel(req.PathParameter("id"))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusAccepted, job)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/maintenance"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+type maintenanceInfo struct {
+	Repo   string                       `json:"repo"`
+	Stats  *pacakimpl.ObjectStats       `json:"stats"`
+	Status *pacakimpl.MaintenanceStatus `json:"status"`
+	// Needed are tasks the next scheduled maintenance will run.
+	Needed  []string `json:"needed"`
+	Running bool     `json:"running"`
+}
+
+type maintenanceOptions struct {
+	Tasks []string `json:"tasks"`
+}
+
+// defaultMaintenanceTasks are run if maintenance is triggered without tasks.
+var defaultMaintenanceTasks = []string{pacakimpl.TaskGC, pacakimpl.TaskCommitGraph, pacakimpl.TaskFsck}
+
+func (api pacakAPI) maintenanceInfo(repo string) (*maintenanceInfo, error) {
+	r, err := api.git.GetRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.ObjectStats()
+	if err != nil {
+		return nil, err
+	}
+	status, err := r.MaintenanceStatus()
+	if err != nil {
+		return nil, err
+	}
+	_, running := api.jobs.Active(maintenance.Job, repo)
+	return &maintenanceInfo{
+		Repo:    repo,
+		Stats:   stats,
+		Status:  status,
+		Needed:  api.maintenance.Policy().Tasks(stats, status, time.Now()),
+		Running: running,
+	}, nil
+}
+
+// Maintenance returns object stats and the last maintenance of all
+// repositories, or only of corrupt ones if 'corrupt=true'.
+func (api pacakAPI) Maintenance(req *restful.Request, resp *restful.Response) {
+	repos, err := api.git.ListRepositories()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	corrupt := req.QueryParameter("corrupt") == "true"
+	res := []*maintenanceInfo{}
+	for _, repo := range repos {
+		info, err := api.maintenanceInfo(repo)
+		if err != nil {
+			logrus.Errorf("Maintenance: %v: %v", repo, err)
+			continue
+		}
+		if !corrupt || info.Status.Corrupt {
+			res = append(res, info)
+		}
+	}
+	resp.WriteEntity(res)
+}
+
+func (api pacakAPI) RepoMaintenance(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	info, err := api.maintenanceInfo(repo)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(info)
+}
+
+// Maintain queues maintenance of the repository with optional tasks and returns its job.
+// 409 is returned if maintenance with other tasks is already queued or running.
+func (api pacakAPI) Maintain(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	opts := maintenanceOptions{}
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(&opts); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	if len(opts.Tasks) == 0 {
+		opts.Tasks = defaultMaintenanceTasks
+	}
+	for _, t := range opts.Tasks {
+		if !pacakimpl.IsMaintenanceTask(t) {
+			resp.WriteErrorString(http.StatusBadRequest, "Unknown maintenance task '"+t+"'")
+			return
+		}
+	}
+	logrus.Infof("Maintain: %v %v", repo, opts.Tasks)
+	job, err := api.maintenance.Trigger(repo, opts.Tasks)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusAccepted, job)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+type mergeOptions struct {
+	Source   string                  `json:"source"`
+	Target   string                  `json:"target"`
+	Strategy pacakimpl.MergeStrategy `json:"strategy"`
+	Message  string                  `json:"message"`
+}
+
+type commitResult struct {
+	Commit string `json:"commit"`
+}
+
+func (api pacakAPI) Merge(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := mergeOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("Merge: %v %v => %v (%v)", repo, opts.Source, opts.Target, opts.Strategy)
+	commit, err := gitRepo.Merge(opts.Source, opts.Target, opts.Strategy, Signature(req), opts.Message)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
+
+type revertOptions struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch"`
+}
+
+func (api pacakAPI) Revert(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := revertOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("Revert: %v %v on %v", repo, opts.Commit, opts.Branch)
+	commit, err := gitRepo.Revert(Signature(req), opts.Branch, opts.Commit)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
+
+type cherryPickOptions struct {
+	Commits []string `json:"commits"`
+	Target  string   `json:"target"`
+}
+
+func (api pacakAPI) CherryPick(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := cherryPickOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("CherryPick: %v %v => %v", repo, opts.Commits, opts.Target)
+	commit, err := gitRepo.CherryPick(Signature(req), opts.Commits, opts.Target)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
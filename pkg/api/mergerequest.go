@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+type mergeRequestOptions struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type commentOptions struct {
+	Body string `json:"body"`
+}
+
+type mergeRequestMergeOptions struct {
+	Strategy pacakimpl.MergeStrategy `json:"strategy"`
+	Message  string                  `json:"message"`
+}
+
+// mergeRequestRepo returns repository and merge request ID of the request.
+// It writes the error response and returns false on failure.
+func (api pacakAPI) mergeRequestRepo(req *restful.Request, resp *restful.Response) (pacakimpl.PacakRepo, int64, bool) {
+	repo := "test/" + req.PathParameter("repo")
+	id := int64(0)
+	if v := req.PathParameter("id"); v != "" {
+		var err error
+		if id, err = strconv.ParseInt(v, 10, 64); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return nil, 0, false
+		}
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return nil, 0, false
+	}
+	return gitRepo, id, true
+}
+
+// MergeRequests lists merge requests, query parameter state filters them.
+func (api pacakAPI) MergeRequests(req *restful.Request, resp *restful.Response) {
+	gitRepo, _, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	res, err := gitRepo.MergeRequests(req.QueryParameter("state"))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(res)
+}
+
+func (api pacakAPI) CreateMergeRequest(req *restful.Request, resp *restful.Response) {
+	opts := mergeRequestOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, _, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	logrus.Infof("CreateMergeRequest: %v %v => %v", req.PathParameter("repo"), opts.Source, opts.Target)
+	mr, err := gitRepo.CreateMergeRequest(Signature(req), opts.Source, opts.Target, opts.Title, opts.Description)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, mr)
+}
+
+func (api pacakAPI) MergeRequest(req *restful.Request, resp *restful.Response) {
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	mr, err := gitRepo.GetMergeRequest(id)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(mr)
+}
+
+func (api pacakAPI) MergeRequestStatus(req *restful.Request, resp *restful.Response) {
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	status, err := gitRepo.MergeRequestStatus(id)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(status)
+}
+
+func (api pacakAPI) MergeRequestDiff(req *restful.Request, resp *restful.Response) {
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err := gitRepo.WriteMergeRequestDiff(buf, id); err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/x-patch; charset=utf-8")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(buf.Bytes())
+}
+
+func (api pacakAPI) CommentMergeRequest(req *restful.Request, resp *restful.Response) {
+	opts := commentOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if opts.Body == "" {
+		resp.WriteErrorString(http.StatusBadRequest, "Comment body is required")
+		return
+	}
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	mr, err := gitRepo.CommentMergeRequest(Signature(req), id, opts.Body)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(mr)
+}
+
+// ApproveMergeRequest approves the merge request on behalf of GIT_EMAIL.
+// Approvals are counted per email, so the header is required.
+func (api pacakAPI) ApproveMergeRequest(req *restful.Request, resp *restful.Response) {
+	if req.HeaderParameter("GIT_EMAIL") == "" {
+		resp.WriteErrorString(http.StatusBadRequest, "GIT_EMAIL header is required to approve")
+		return
+	}
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	mr, err := gitRepo.ApproveMergeRequest(Signature(req), id)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(mr)
+}
+
+func (api pacakAPI) CloseMergeRequest(req *restful.Request, resp *restful.Response) {
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	mr, err := gitRepo.CloseMergeRequest(Signature(req), id)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(mr)
+}
+
+// MergeMergeRequest merges the merge request. Body is optional.
+func (api pacakAPI) MergeMergeRequest(req *restful.Request, resp *restful.Response) {
+	opts := mergeRequestMergeOptions{}
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(&opts); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	gitRepo, id, ok := api.mergeRequestRepo(req, resp)
+	if !ok {
+		return
+	}
+	logrus.Infof("MergeMergeRequest: %v #%v", req.PathParameter("repo"), id)
+	mr, err := gitRepo.MergeMergeRequest(Signature(req), id, opts.Strategy, opts.Message)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(mr)
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+type mirrorInfo struct {
+	pacakimpl.MirrorConfig
+	Status  *pacakimpl.MirrorStatus `json:"status"`
+	Running bool                    `json:"running"`
+}
+
+func (api pacakAPI) mirrorInfo(repo string, gitRepo pacakimpl.PacakRepo) (*mirrorInfo, error) {
+	c, err := gitRepo.Mirror()
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, nil
+	}
+	status, err := gitRepo.MirrorStatus()
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorInfo{
+		MirrorConfig: c.Public(),
+		Status:       status,
+		Running:      api.mirrors.Running(repo),
+	}, nil
+}
+
+// Mirror returns mirror config of the repository with status of the last sync.
+func (api pacakAPI) Mirror(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	info, err := api.mirrorInfo(repo, gitRepo)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	if info == nil {
+		resp.WriteErrorString(http.StatusNotFound, "Repository '"+repo+"' is not a mirror")
+		return
+	}
+	resp.WriteEntity(info)
+}
+
+// SetMirror makes '{repo}' a mirror of the remote. Repository is created
+// if it does not exist. Sync is started immediately and replaces all
+// branches and tags, so a regular repository which is not empty is
+// converted only with 'force=true'.
+func (api pacakAPI) SetMirror(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("repo")
+	repo := "test/" + name
+	c := pacakimpl.MirrorConfig{}
+	if err := req.ReadEntity(&c); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if err := c.Validate(); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	logrus.Infof("SetMirror: %v => %v", c.Public().URL, repo)
+	exists := api.git.ExistsRepository(repo)
+	if !exists {
+		if !validRepoName(name) {
+			resp.WriteErrorString(http.StatusBadRequest, "Invalid repository name '"+name+"'")
+			return
+		}
+		if err := api.git.InitMirror(repo, c); err != nil {
+			writeError(resp, err)
+			return
+		}
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	if exists && req.QueryParameter("force") != "true" {
+		if err := checkEmptyRegular(repo, gitRepo); err != nil {
+			writeError(resp, err)
+			return
+		}
+	}
+	if err := gitRepo.SetMirror(&c); err != nil {
+		writeError(resp, err)
+		return
+	}
+	if _, err := api.mirrors.Trigger(repo); err != nil {
+		writeError(resp, err)
+		return
+	}
+	info, err := api.mirrorInfo(repo, gitRepo)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(info)
+}
+
+// checkEmptyRegular returns RepositoryNotEmpty if the repository is
+// not a mirror and has branches or tags.
+func checkEmptyRegular(repo string, gitRepo pacakimpl.PacakRepo) error {
+	c, err := gitRepo.Mirror()
+	if err != nil || c != nil {
+		return err
+	}
+	refs, err := gitRepo.Refs()
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return errors.RepositoryNotEmpty{Name: repo}
+	}
+	return nil
+}
+
+// DeleteMirror stops mirroring, the repository becomes a regular one.
+func (api pacakAPI) DeleteMirror(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("DeleteMirror: %v", repo)
+	if err := gitRepo.SetMirror(nil); err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// SyncMirror queues sync of the mirror and returns its job.
+func (api pacakAPI) SyncMirror(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	c, err := gitRepo.Mirror()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	if c == nil {
+		resp.WriteErrorString(http.StatusNotFound, "Repository '"+repo+"' is not a mirror")
+		return
+	}
+	job, err := api.mirrors.Trigger(repo)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusAccepted, job)
+}
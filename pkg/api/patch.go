@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// ApplyPatch commits unified diff or 'git format-patch' mbox from request body to the branch.
+func (api pacakAPI) ApplyPatch(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	branch := req.PathParameter("branch")
+	patch, err := ioutil.ReadAll(req.Request.Body)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("ApplyPatch: %v %v", repo, branch)
+	commit, err := gitRepo.ApplyPatch(Signature(req), branch, patch, req.QueryParameter("message"))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
+
+// Patch writes commit '{sha}' or range '{base}..{head}' as format-patch
+// output or as plain diff if 'format=diff' is given.
+func (api pacakAPI) Patch(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	base, head := "", req.PathParameter("rev")
+	if parts := strings.SplitN(head, "..", 2); len(parts) == 2 {
+		base, head = parts[0], parts[1]
+	}
+	format := pacakimpl.PatchFormat(req.QueryParameter("format"))
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	// Write to buffer first to be able to report errors properly.
+	buf := new(bytes.Buffer)
+	if err := gitRepo.WritePatch(buf, base, head, format); err != nil {
+		writeError(resp, err)
+		return
+	}
+	if format == pacakimpl.PatchDiff {
+		resp.Header().Set("Content-Type", "text/x-patch; charset=utf-8")
+	} else {
+		resp.Header().Set("Content-Type", "application/mbox")
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(buf.Bytes())
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+func (api pacakAPI) Protection(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	rules, err := gitRepo.Protection()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(rules)
+}
+
+// SetProtection replaces branch and tag protection rules of the repository.
+func (api pacakAPI) SetProtection(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	rules := pacakimpl.ProtectionRules{}
+	if err := req.ReadEntity(&rules); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("SetProtection: %v", repo)
+	if err := gitRepo.SetProtection(rules); err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := gitRepo.Protection()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(res)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+type pushMirrorOptions struct {
+	// URL is file://, http:// or https:// URL of the remote.
+	URL string `json:"url"`
+}
+
+type pushMirrorInfo struct {
+	pacakimpl.PushMirror
+	Running bool `json:"running"`
+}
+
+func (api pacakAPI) pushMirrorRepo(req *restful.Request, resp *restful.Response) (string, pacakimpl.PacakRepo) {
+	repo := "test/" + req.PathParameter("repo")
+	if !api.git.ExistsRepository(repo) {
+		writeError(resp, errors.RepositoryNotFound{Name: repo})
+		return "", nil
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return "", nil
+	}
+	return repo, gitRepo
+}
+
+func (api pacakAPI) pushMirrorInfo(repo string, m pacakimpl.PushMirror) pushMirrorInfo {
+	return pushMirrorInfo{PushMirror: m.Public(), Running: api.pushers.Running(repo, m.ID)}
+}
+
+// PushMirrors returns push mirrors of the repository with status of the last push.
+func (api pacakAPI) PushMirrors(req *restful.Request, resp *restful.Response) {
+	repo, gitRepo := api.pushMirrorRepo(req, resp)
+	if gitRepo == nil {
+		return
+	}
+	mirrors, err := gitRepo.PushMirrors()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	res := make([]pushMirrorInfo, 0, len(mirrors))
+	for _, m := range mirrors {
+		res = append(res, api.pushMirrorInfo(repo, m))
+	}
+	resp.WriteEntity(res)
+}
+
+// AddPushMirror adds a push mirror and pushes all refs to it.
+func (api pacakAPI) AddPushMirror(req *restful.Request, resp *restful.Response) {
+	repo, gitRepo := api.pushMirrorRepo(req, resp)
+	if gitRepo == nil {
+		return
+	}
+	opts := pushMirrorOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	m, err := gitRepo.AddPushMirror(opts.URL)
+	if err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	logrus.Infof("AddPushMirror: %v => %v", repo, m.Public().URL)
+	api.pushers.Trigger(repo, m.ID)
+	resp.WriteHeaderAndEntity(http.StatusCreated, api.pushMirrorInfo(repo, *m))
+}
+
+func (api pacakAPI) DeletePushMirror(req *restful.Request, resp *restful.Response) {
+	repo, gitRepo := api.pushMirrorRepo(req, resp)
+	if gitRepo == nil {
+		return
+	}
+	logrus.Infof("DeletePushMirror: %v %v", repo, req.PathParameter("id"))
+	if err := gitRepo.DeletePushMirror(req.PathParameter("id")); err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// SyncPushMirror starts push to the push mirror in background.
+func (api pacakAPI) SyncPushMirror(req *restful.Request, resp *restful.Response) {
+	repo, gitRepo := api.pushMirrorRepo(req, resp)
+	if gitRepo == nil {
+		return
+	}
+	id := req.PathParameter("id")
+	mirrors, err := gitRepo.PushMirrors()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	for _, m := range mirrors {
+		if m.ID == id {
+			api.pushers.Trigger(repo, id)
+			resp.WriteHeaderAndEntity(http.StatusAccepted, api.pushMirrorInfo(repo, m))
+			return
+		}
+	}
+	writeError(resp, errors.PushMirrorNotFound{ID: id})
+}
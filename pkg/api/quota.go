@@ -0,0 +1,46 @@
+package api
+
+import (
+	"path"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+)
+
+type repoUsage struct {
+	pacakimpl.Usage
+	Namespace *pacakimpl.NamespaceUsage `json:"namespace"`
+}
+
+// Quotas returns disk quotas. Quotas are configured by the operator with -quota-config.
+func (api pacakAPI) Quotas(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(api.git.Quotas())
+}
+
+// Usage returns disk usage of all namespaces and their repositories.
+func (api pacakAPI) Usage(req *restful.Request, resp *restful.Response) {
+	usage, err := api.git.NamespaceUsages()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(usage)
+}
+
+// RepoUsage returns disk usage of the repository and its namespace.
+func (api pacakAPI) RepoUsage(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	usage, err := api.git.Usage(repo)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	ns, err := api.git.NamespaceUsage(path.Dir(repo))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	// Repositories are listed by /admin/usage.
+	ns.Repos = nil
+	resp.WriteEntity(repoUsage{Usage: *usage, Namespace: ns})
+}
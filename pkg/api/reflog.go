@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+	"github.com/sirupsen/logrus"
+)
+
+type resetOptions struct {
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+func (api pacakAPI) Reset(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := resetOptions{}
+	if err := req.ReadEntity(&opts); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("Reset: %v %v to %v", repo, opts.Branch, opts.Commit)
+	commit, err := gitRepo.ResetBranch(Signature(req), opts.Branch, opts.Commit)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
+
+func (api pacakAPI) RefLog(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	limit := 0
+	if v := req.QueryParameter("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			resp.WriteError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	log, err := gitRepo.RefLog(req.QueryParameter("ref"), limit)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(log)
+}
+
+func (api pacakAPI) UndoRefUpdate(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	id, err := strconv.ParseInt(req.PathParameter("id"), 10, 64)
+	if err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	logrus.Infof("Undo: %v ref update %v", repo, id)
+	commit, err := gitRepo.UndoRefUpdate(Signature(req), id)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(commitResult{Commit: commit})
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// S3 gateway exposes read-only subset of S3 API: ListObjectsV2, GetObject
+// and HeadObject. Bucket is a repository and object keys are '{rev}/path'.
+// Rev may contain '/', it is the longest branch or tag the key starts with,
+// or the first key segment, e.g. commit id. Only path-style requests are
+// supported.
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
+	Xmlns                 string     `xml:"xmlns,attr"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	Delimiter             string     `xml:"Delimiter,omitempty"`
+	StartAfter            string     `xml:"StartAfter,omitempty"`
+	ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	EncodingType          string     `xml:"EncodingType,omitempty"`
+	KeyCount              int        `xml:"KeyCount"`
+	MaxKeys               int        `xml:"MaxKeys"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	Contents              []s3Object `xml:"Contents"`
+	CommonPrefixes        []s3Prefix `xml:"CommonPrefixes"`
+}
+
+type s3LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+// S3 serves S3 gateway requests.
+func (api pacakAPI) S3(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Only read requests are supported")
+		return
+	}
+	if bucket == "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Listing buckets is not supported")
+		return
+	}
+	repo := "test/" + bucket
+	if !api.git.ExistsRepository(repo) {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	query := r.URL.Query()
+	switch {
+	case key != "":
+		api.s3GetObject(w, r, gitRepo, key)
+	case query["location"] != nil:
+		writeS3XML(w, http.StatusOK, s3LocationConstraint{Xmlns: s3Namespace})
+	case query.Get("list-type") == "2":
+		api.s3ListObjectsV2(w, r, gitRepo, bucket)
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 listing is supported")
+	}
+}
+
+func (api pacakAPI) s3GetObject(w http.ResponseWriter, r *http.Request, repo pacakimpl.PacakRepo, key string) {
+	refs, err := s3Refs(repo)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	rev, path := s3SplitKey(refs, key)
+	if rev == "" || path == "" {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	commit, err := repo.GetRev(rev)
+	if err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	fi, err := repo.StatFileAtRev(rev, path)
+	if err != nil || fi.IsDir() {
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("S3: stat %v failed: %v", key, err)
+		}
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	reader, err := repo.GetFileAtRev(rev, path)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", `"`+fi.(*pacakimpl.GitFileInfo).ID()+`"`)
+	http.ServeContent(w, r, path, commit.Committer.When, bytes.NewReader(data))
+}
+
+func (api pacakAPI) s3ListObjectsV2(w http.ResponseWriter, r *http.Request, repo pacakimpl.PacakRepo, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		v, err := base64.URLEncoding.DecodeString(token)
+		if err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
+		}
+		after = string(v)
+	}
+
+	// List branches and tags matching the prefix. If the prefix contains
+	// a revision which is not a branch or tag, only it is listed.
+	refs, err := s3Refs(repo)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	var revs []string
+	for _, rev := range refs {
+		if strings.HasPrefix(rev+"/", prefix) || strings.HasPrefix(prefix, rev+"/") {
+			revs = append(revs, rev)
+		}
+	}
+	if rev, _ := s3SplitKey(refs, prefix); len(revs) == 0 && rev != "" {
+		revs = []string{rev}
+	}
+
+	objects := []s3Object{}
+	for _, rev := range revs {
+		commit, err := repo.GetRev(rev)
+		if err != nil {
+			// Unknown revision has no keys.
+			continue
+		}
+		files, err := repo.ListFilesAtRev(rev)
+		if err != nil {
+			writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		for _, f := range files {
+			key := rev + f.Name()
+			if f.IsDir() || !strings.HasPrefix(key, prefix) || key <= after {
+				continue
+			}
+			// Key is shadowed by a longer branch or tag.
+			if r, _ := s3SplitKey(refs, key); r != rev {
+				continue
+			}
+			objects = append(objects, s3Object{
+				Key:          key,
+				LastModified: commit.Committer.When.UTC().Format(s3TimeFormat),
+				ETag:         `"` + f.(*pacakimpl.GitFileInfo).ID() + `"`,
+				Size:         f.Size(),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	result := s3ListBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxKeys,
+	}
+	var last string
+	for _, o := range objects {
+		if delimiter != "" {
+			if i := strings.Index(o.Key[len(prefix):], delimiter); i >= 0 {
+				common := o.Key[:len(prefix)+i+len(delimiter)]
+				n := len(result.CommonPrefixes)
+				if n > 0 && result.CommonPrefixes[n-1].Prefix == common {
+					continue
+				}
+				if result.KeyCount == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, s3Prefix{Prefix: common})
+				result.KeyCount++
+				// Continue after all keys with the common prefix.
+				last = common + "\xff"
+				continue
+			}
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, o)
+		result.KeyCount++
+		last = o.Key
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(last))
+	}
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		result.Prefix = s3EscapeKey(result.Prefix)
+		result.Delimiter = s3EscapeKey(result.Delimiter)
+		result.StartAfter = s3EscapeKey(result.StartAfter)
+		for i := range result.Contents {
+			result.Contents[i].Key = s3EscapeKey(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = s3EscapeKey(result.CommonPrefixes[i].Prefix)
+		}
+	}
+	writeS3XML(w, http.StatusOK, result)
+}
+
+// s3EscapeKey URL-encodes key leaving slashes as is.
+// s3Refs returns names of branches and tags of the repository.
+func s3Refs(repo pacakimpl.PacakRepo) ([]string, error) {
+	branches, err := repo.GetBranches()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := repo.TagList()
+	if err != nil {
+		return nil, err
+	}
+	return append(branches, tags...), nil
+}
+
+// s3SplitKey splits key into revision and path. Revision is the longest
+// of refs the key starts with, or the first segment of the key. Empty
+// revision is returned if key has no '/'.
+func s3SplitKey(refs []string, key string) (string, string) {
+	rev := ""
+	for _, ref := range refs {
+		if len(ref) > len(rev) && strings.HasPrefix(key, ref+"/") {
+			rev = ref
+		}
+	}
+	if rev == "" {
+		i := strings.Index(key, "/")
+		if i < 0 {
+			return "", ""
+		}
+		rev = key[:i]
+	}
+	return rev, key[len(rev)+1:]
+}
+
+func s3EscapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func writeS3XML(w http.ResponseWriter, status int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		logrus.Errorf("S3: failed marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeS3XML(w, status, s3Error{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+// startS3 starts S3 gateway on given address.
+func startS3(api pacakAPI, addr string) {
+	logrus.Infof("S3 gateway listen in %v", addr)
+	if err := http.ListenAndServe(addr, WrapLogger(http.HandlerFunc(api.S3))); err != nil {
+		logrus.Errorln(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+)
+
+// Search greps files of '{rev}'. Query parameters: q, regex, ignoreCase,
+// path (glob, may be repeated), context, offset and limit.
+func (api pacakAPI) Search(req *restful.Request, resp *restful.Response) {
+	repo := "test/" + req.PathParameter("repo")
+	opts := pacakimpl.GrepOptions{
+		Query:      req.QueryParameter("q"),
+		Regex:      req.QueryParameter("regex") == "true",
+		IgnoreCase: req.QueryParameter("ignoreCase") == "true",
+		Paths:      req.Request.URL.Query()["path"],
+	}
+	for name, v := range map[string]*int{"context": &opts.Context, "offset": &opts.Offset, "limit": &opts.Limit} {
+		if s := req.QueryParameter(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				resp.WriteErrorString(http.StatusBadRequest, "Invalid "+name+"="+s)
+				return
+			}
+			*v = n
+		}
+	}
+	if opts.Query == "" {
+		resp.WriteErrorString(http.StatusBadRequest, "Query parameter 'q' is required")
+		return
+	}
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	res, err := gitRepo.Grep(req.PathParameter("rev"), opts)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(res)
+}
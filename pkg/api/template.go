@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+)
+
+type initOptions struct {
+	// Template is name of repository template to init repository from.
+	Template string `json:"template"`
+	// Variables are substituted in paths and contents of template files.
+	Variables map[string]string `json:"variables"`
+}
+
+// Templates returns names of repository templates.
+func (api pacakAPI) Templates(req *restful.Request, resp *restful.Response) {
+	templates, err := api.git.Templates()
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteEntity(templates)
+}
+
+// readInitOptions reads optional init options from the body.
+// Template may also be passed with 'template' query parameter.
+func readInitOptions(req *restful.Request) (initOptions, error) {
+	opts := initOptions{}
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(&opts); err != nil {
+			return opts, err
+		}
+	}
+	if t := req.QueryParameter("template"); t != "" {
+		opts.Template = t
+	}
+	return opts, nil
+}
+
+func (api pacakAPI) initRepository(req *restful.Request, resp *restful.Response, repo string) {
+	opts, err := readInitOptions(req)
+	if err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if opts.Template == "" {
+		err = api.git.InitRepository(Signature(req), repo, nil)
+	} else {
+		err = api.git.InitRepositoryFromTemplate(Signature(req), repo, opts.Template, opts.Variables)
+	}
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
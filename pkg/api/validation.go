@@ -0,0 +1,11 @@
+package api
+
+import (
+	"github.com/emicklei/go-restful"
+)
+
+// ValidationRules returns rules changes are validated with before push.
+// Rules are configured by the operator with -validation-config.
+func (api pacakAPI) ValidationRules(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(api.git.ValidationRules())
+}
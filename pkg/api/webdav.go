@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV serves writable view of a repository branch at /webdav/{repo}/{branch}/.
+// PUT, DELETE, MKCOL and MOVE are committed to the branch.
+func (api pacakAPI) WebDAV(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repo := "test/" + vars["repo"]
+	branch := vars["branch"]
+	gitRepo, err := api.git.GetRepository(repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	committer := signature(r)
+	// Writes of one client are batched to a single commit.
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	client = client + "/" + committer.Email
+
+	h := &webdav.Handler{
+		Prefix:     "/webdav/" + vars["repo"] + "/" + branch,
+		FileSystem: api.dav.FileSystem(repo, gitRepo, branch, client, committer),
+		LockSystem: api.dav.LockSystem(repo, branch),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logrus.Debugf("WebDAV %v %v: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/kuberlab/pacak/pkg/webhook"
+)
+
+// Webhooks lists hooks. Query parameter repo limits hooks to ones
+// called on events of the repository.
+func (api pacakAPI) Webhooks(req *restful.Request, resp *restful.Response) {
+	resp.WriteEntity(api.hooks.List(req.QueryParameter("repo")))
+}
+
+// AddWebhook subscribes a new hook. Body is a webhook.Hook, id is assigned by the server.
+func (api pacakAPI) AddWebhook(req *restful.Request, resp *restful.Response) {
+	h := webhook.Hook{}
+	if err := req.ReadEntity(&h); err != nil {
+		resp.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	res, err := api.hooks.Add(h)
+	if err != nil {
+		resp.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, res)
+}
+
+func (api pacakAPI) Webhook(req *restful.Request, resp *restful.Response) {
+	h := api.hooks.Get(req.PathParameter("id"))
+	if h == nil {
+		resp.WriteErrorString(http.StatusNotFound, "Webhook not found")
+		return
+	}
+	resp.WriteEntity(h)
+}
+
+func (api pacakAPI) DeleteWebhook(req *restful.Request, resp *restful.Response) {
+	found, err := api.hooks.Delete(req.PathParameter("id"))
+	if err != nil {
+		resp.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		resp.WriteErrorString(http.StatusNotFound, "Webhook not found")
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries returns pending and recent deliveries of the hook, newest first.
+func (api pacakAPI) WebhookDeliveries(req *restful.Request, resp *restful.Response) {
+	id := req.PathParameter("id")
+	if api.hooks.Get(id) == nil {
+		resp.WriteErrorString(http.StatusNotFound, "Webhook not found")
+		return
+	}
+	resp.WriteEntity(api.hooks.Deliveries(id))
+}
@@ -0,0 +1,261 @@
+// Package backup writes all repositories as git bundles into a backup
+// directory together with a manifest and checksums.
+//
+// Each run creates a directory named by its start time under the target:
+//
+//	<target>/20261018T120000Z/manifest.json
+//	<target>/20261018T120000Z/SHA256SUMS
+//	<target>/20261018T120000Z/repos/<repo>.bundle
+//
+// Full bundles can be restored with repository import. Incremental bundles
+// require commits listed in 'since' of the manifest entry and are applied
+// with 'git fetch <bundle> "+refs/*:refs/*"' on the restored repository.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	manifestFile  = "manifest.json"
+	checksumsFile = "SHA256SUMS"
+	timeFormat    = "20060102T150405Z"
+)
+
+// Manifest describes a backup run.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	// Base is the directory of the previous backup incremental bundles are based on.
+	Base         string       `json:"base,omitempty"`
+	Repositories []Repository `json:"repositories"`
+}
+
+// Repository describes backup of a single repository.
+type Repository struct {
+	Name string `json:"name"`
+	// Bundle is the path of the bundle relative to the backup directory.
+	// It is empty if the repository is empty or unchanged since the base backup.
+	Bundle string `json:"bundle,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Refs are branches and tags with commits they point to.
+	Refs map[string]string `json:"refs"`
+	// Since are prerequisite commits of incremental bundle.
+	Since     []string `json:"since,omitempty"`
+	Unchanged bool     `json:"unchanged,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Failed returns number of repositories failed to back up.
+func (m *Manifest) Failed() int {
+	n := 0
+	for _, r := range m.Repositories {
+		if r.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Run backs up all repositories into a new directory under target.
+// If incremental, bundles contain only commits added since the latest
+// backup in target. Failures of single repositories are recorded in the
+// manifest and do not stop the backup.
+func Run(g pacakimpl.GitInterface, target string, incremental bool) (*Manifest, string, error) {
+	var base *Manifest
+	baseDir := ""
+	if incremental {
+		var err error
+		if base, baseDir, err = Latest(target); err != nil {
+			return nil, "", err
+		}
+	}
+	m := &Manifest{Created: time.Now().UTC(), Base: baseDir}
+	dir := path.Join(target, m.Created.Format(timeFormat))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, "", err
+	}
+	repos, err := g.ListRepositories()
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(repos)
+	prev := map[string]Repository{}
+	if base != nil {
+		for _, r := range base.Repositories {
+			if r.Error == "" {
+				prev[r.Name] = r
+			}
+		}
+	}
+	for _, repo := range repos {
+		r := backupRepository(g, dir, repo, prev[repo].Refs)
+		if r.Error != "" {
+			logrus.Errorf("Backup of %v failed: %v", repo, r.Error)
+		}
+		m.Repositories = append(m.Repositories, r)
+	}
+	if err := writeManifest(dir, m); err != nil {
+		return nil, "", err
+	}
+	return m, dir, nil
+}
+
+func backupRepository(g pacakimpl.GitInterface, dir, repo string, prevRefs map[string]string) Repository {
+	r := Repository{Name: repo, Refs: map[string]string{}}
+	gitRepo, err := g.GetRepository(repo)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if r.Refs, err = gitRepo.Refs(); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if prevRefs != nil && equalRefs(prevRefs, r.Refs) {
+		r.Unchanged = true
+		return r
+	}
+	since := []string{}
+	seen := map[string]bool{}
+	for _, id := range prevRefs {
+		if !seen[id] {
+			seen[id] = true
+			since = append(since, id)
+		}
+	}
+	sort.Strings(since)
+
+	r.Bundle = path.Join("repos", repo+".bundle")
+	bundlePath := path.Join(dir, r.Bundle)
+	refs, size, sum, err := writeBundle(gitRepo, bundlePath, since)
+	if errors.IsRevisionNotFound(err) {
+		// Commits of the base backup are gone after force push, make full bundle.
+		since = nil
+		refs, size, sum, err = writeBundle(gitRepo, bundlePath, since)
+	}
+	switch {
+	case errors.IsEmptyBundle(err):
+		// Only refs were removed or repository has no refs at all.
+		r.Bundle = ""
+	case err != nil:
+		r.Bundle = ""
+		r.Error = err.Error()
+	default:
+		// Refs may have changed since they were read, record the bundled ones.
+		r.Refs, r.Size, r.SHA256, r.Since = refs, size, sum, since
+	}
+	return r
+}
+
+func writeBundle(gitRepo pacakimpl.PacakRepo, bundlePath string, since []string) (map[string]string, int64, string, error) {
+	if err := os.MkdirAll(path.Dir(bundlePath), os.ModePerm); err != nil {
+		return nil, 0, "", err
+	}
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(f, h)}
+	refs, err := gitRepo.WriteBundle(cw, since)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(bundlePath)
+		return nil, 0, "", err
+	}
+	return refs, cw.n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func equalRefs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// writeManifest writes manifest and checksums in sha256sum format.
+// Manifest is written last, so backups without it are incomplete.
+func writeManifest(dir string, m *Manifest) error {
+	sums := new(strings.Builder)
+	for _, r := range m.Repositories {
+		if r.Bundle != "" {
+			fmt.Fprintf(sums, "%s  %s\n", r.SHA256, r.Bundle)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dir, checksumsFile), []byte(sums.String()), 0666); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, manifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(dir, manifestFile))
+}
+
+// Latest returns manifest of the latest complete backup in target and
+// its directory name. Nil manifest is returned if there are no backups.
+func Latest(target string) (*Manifest, string, error) {
+	files, err := ioutil.ReadDir(target)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if !files[i].IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(target, files[i].Name(), manifestFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		m := &Manifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, "", fmt.Errorf("Failed read manifest of %s - %v", files[i].Name(), err)
+		}
+		return m, files[i].Name(), nil
+	}
+	return nil, "", nil
+}
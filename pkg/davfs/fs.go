@@ -0,0 +1,405 @@
+package davfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"golang.org/x/net/webdav"
+)
+
+// keepFile is created by Mkdir since git does not track empty directories.
+const keepFile = ".gitkeep"
+
+// FileSystem is a webdav.FileSystem view of a repository branch.
+// Reads are served from the branch head with pending writes applied on top.
+// Writes are handed over to the Store to be committed.
+type FileSystem struct {
+	store     *Store
+	repoName  string
+	repo      pacakimpl.PacakRepo
+	branch    string
+	client    string
+	committer git.Signature
+
+	// tree caches the view of the branch, reset on every write.
+	tree map[string]*fileInfo
+	rev  string
+}
+
+type fileInfo struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// view returns all files and directories of the branch keyed by absolute path.
+func (fs *FileSystem) view() (map[string]*fileInfo, error) {
+	if fs.tree != nil {
+		return fs.tree, nil
+	}
+	// Branch which does not exist yet is created from master on first save.
+	fs.rev = "master"
+	branches, err := fs.repo.GetBranches()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range branches {
+		if b == fs.branch {
+			fs.rev = fs.branch
+		}
+	}
+	head, err := fs.repo.GetRev(fs.rev)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.repo.ListFilesAtRev(fs.rev)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]*fileInfo{}
+	for _, f := range files {
+		// Directories are derived from files, so deleted ones disappear.
+		if !f.IsDir() {
+			tree[f.Name()] = &fileInfo{name: path.Base(f.Name()), size: f.Size(), modTime: head.Committer.When}
+		}
+	}
+	for p, c := range fs.store.pending(fs.repoName, fs.branch) {
+		if c.deleted {
+			delete(tree, p)
+		} else {
+			tree[p] = &fileInfo{name: path.Base(p), size: int64(len(c.data)), modTime: c.when}
+		}
+	}
+	for p, fi := range tree {
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			if d, ok := tree[dir]; ok {
+				if d.modTime.Before(fi.modTime) {
+					d.modTime = fi.modTime
+				}
+				continue
+			}
+			tree[dir] = &fileInfo{name: path.Base(dir), dir: true, size: 4096, modTime: fi.modTime}
+		}
+	}
+	tree["/"] = &fileInfo{name: "/", dir: true, size: 4096, modTime: head.Committer.When}
+	fs.tree = tree
+	return tree, nil
+}
+
+// under returns paths of all files at name or below it.
+func (fs *FileSystem) under(name string) ([]string, error) {
+	tree, err := fs.view()
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for p, fi := range tree {
+		if fi.dir {
+			continue
+		}
+		if p == name || name == "/" || strings.HasPrefix(p, name+"/") {
+			res = append(res, p)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (fs *FileSystem) read(name string) ([]byte, error) {
+	if c, ok := fs.store.pending(fs.repoName, fs.branch)[name]; ok && !c.deleted {
+		return c.data, nil
+	}
+	r, err := fs.repo.GetFileAtRev(fs.rev, strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (fs *FileSystem) apply(changes map[string]*change) {
+	fs.store.apply(fs, changes)
+	fs.tree = nil
+}
+
+func (fs *FileSystem) parentIsDir(name string) (bool, error) {
+	tree, err := fs.view()
+	if err != nil {
+		return false, err
+	}
+	parent, ok := tree[path.Dir(name)]
+	return ok && parent.dir, nil
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = clean(name)
+	tree, err := fs.view()
+	if err != nil {
+		return err
+	}
+	if _, ok := tree[name]; ok {
+		return os.ErrExist
+	}
+	if ok, err := fs.parentIsDir(name); err != nil {
+		return err
+	} else if !ok {
+		return os.ErrNotExist
+	}
+	fs.apply(map[string]*change{
+		path.Join(name, keepFile): {data: []byte{}, when: time.Now()},
+	})
+	return nil
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = clean(name)
+	tree, err := fs.view()
+	if err != nil {
+		return nil, err
+	}
+	fi, exists := tree[name]
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if exists && fi.dir {
+		if writable {
+			return nil, os.ErrPermission
+		}
+		return &file{fs: fs, name: name, info: fi}, nil
+	}
+	if !exists {
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		if ok, err := fs.parentIsDir(name); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, os.ErrNotExist
+		}
+		fi = &fileInfo{name: path.Base(name), modTime: time.Now()}
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, os.ErrExist
+	}
+
+	f := &file{fs: fs, name: name, info: fi, writable: writable}
+	if flag&os.O_TRUNC != 0 || !exists {
+		f.dirty = writable
+		f.data = []byte{}
+	} else if f.data, err = fs.read(name); err != nil {
+		return nil, err
+	}
+	if flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.data))
+	}
+	return f, nil
+}
+
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	if name == "/" {
+		return os.ErrPermission
+	}
+	files, err := fs.under(name)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	changes := map[string]*change{}
+	now := time.Now()
+	for _, p := range files {
+		changes[p] = &change{deleted: true, when: now}
+	}
+	fs.apply(changes)
+	return nil
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	if oldName == "/" || newName == "/" {
+		return os.ErrPermission
+	}
+	if strings.HasPrefix(newName, oldName+"/") {
+		return errors.New("cannot move directory into itself")
+	}
+	tree, err := fs.view()
+	if err != nil {
+		return err
+	}
+	if _, ok := tree[oldName]; !ok {
+		return os.ErrNotExist
+	}
+	if _, ok := tree[newName]; ok {
+		return os.ErrExist
+	}
+	if ok, err := fs.parentIsDir(newName); err != nil {
+		return err
+	} else if !ok {
+		return os.ErrNotExist
+	}
+	files, err := fs.under(oldName)
+	if err != nil {
+		return err
+	}
+	changes := map[string]*change{}
+	now := time.Now()
+	for _, p := range files {
+		data, err := fs.read(p)
+		if err != nil {
+			return err
+		}
+		changes[p] = &change{deleted: true, when: now}
+		changes[newName+strings.TrimPrefix(p, oldName)] = &change{data: data, when: now}
+	}
+	fs.apply(changes)
+	return nil
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	tree, err := fs.view()
+	if err != nil {
+		return nil, err
+	}
+	fi, ok := tree[clean(name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return fi, nil
+}
+
+// file is an in-memory webdav.File. Written data is handed over
+// to the Store on Close.
+type file struct {
+	fs       *FileSystem
+	name     string
+	info     *fileInfo
+	data     []byte
+	pos      int64
+	writable bool
+	dirty    bool
+}
+
+func (f *file) Close() error {
+	if !f.dirty {
+		return nil
+	}
+	f.dirty = false
+	f.fs.apply(map[string]*change{
+		f.name: {data: f.data, when: time.Now()},
+	})
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, os.ErrInvalid
+	}
+	if f.pos >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, os.ErrPermission
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	n := copy(f.data[f.pos:], p)
+	f.pos += int64(n)
+	f.dirty = true
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	// Position of a directory is the number of entries already listed.
+	if f.info.dir {
+		return 0, os.ErrInvalid
+	}
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += f.pos
+	case io.SeekEnd:
+		pos += int64(len(f.data))
+	}
+	if pos < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+	tree, err := f.fs.view()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for p := range tree {
+		if p != "/" && path.Dir(p) == f.name {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	// Continue listing from the previous call. Entries may have been
+	// removed since then.
+	if f.pos > int64(len(names)) {
+		f.pos = int64(len(names))
+	}
+	names = names[f.pos:]
+	if count > 0 && len(names) > count {
+		names = names[:count]
+	}
+	if count > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	res := make([]os.FileInfo, 0, len(names))
+	for _, p := range names {
+		res = append(res, tree[p])
+	}
+	f.pos += int64(len(names))
+	return res, nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.info.dir {
+		return f.info, nil
+	}
+	return &fileInfo{
+		name:    f.info.name,
+		size:    int64(len(f.data)),
+		modTime: f.info.modTime,
+	}, nil
+}
@@ -0,0 +1,268 @@
+package davfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// Store collects writes made through WebDAV and turns them into commits.
+// Writes from one client to one branch are batched and saved with a single
+// PacakRepo.Save once the client stays idle for the configured window.
+type Store struct {
+	idle time.Duration
+
+	lock sync.Mutex
+	// batches maintains pending batches per repo branch, oldest first.
+	batches map[string][]*batch
+	// locks maintains WebDAV lock systems per repo branch.
+	locks map[string]webdav.LockSystem
+}
+
+type change struct {
+	data    []byte
+	deleted bool
+	when    time.Time
+}
+
+type batch struct {
+	client    string
+	repo      pacakimpl.PacakRepo
+	branch    string
+	committer git.Signature
+	changes   map[string]*change
+	timer     *time.Timer
+	// flushing is set once batch is being saved. New writes go to a new batch.
+	flushing bool
+	// failures is the number of failed attempts to save the batch.
+	failures uint
+}
+
+const (
+	// maxSaveAttempts is the number of attempts after which a batch is dropped.
+	maxSaveAttempts = 10
+	// maxRetryDelay limits the delay between attempts to save a failed batch.
+	maxRetryDelay = 10 * time.Minute
+)
+
+// NewStore initializes and returns a new Store which commits
+// batched writes after idle time without new writes.
+func NewStore(idle time.Duration) *Store {
+	return &Store{
+		idle:    idle,
+		batches: make(map[string][]*batch),
+		locks:   make(map[string]webdav.LockSystem),
+	}
+}
+
+func branchKey(repoName, branch string) string {
+	return repoName + ":" + branch
+}
+
+// LockSystem returns lock system shared by all clients of given repo branch.
+func (s *Store) LockSystem(repoName, branch string) webdav.LockSystem {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := branchKey(repoName, branch)
+	ls, ok := s.locks[key]
+	if !ok {
+		ls = webdav.NewMemLS()
+		s.locks[key] = ls
+	}
+	return ls
+}
+
+// FileSystem returns webdav.FileSystem of given repo branch for a client.
+// Client identifies the batch writes are collected to.
+func (s *Store) FileSystem(repoName string, repo pacakimpl.PacakRepo, branch, client string, committer git.Signature) *FileSystem {
+	return &FileSystem{
+		store:     s,
+		repoName:  repoName,
+		repo:      repo,
+		branch:    branch,
+		client:    client,
+		committer: committer,
+	}
+}
+
+func (s *Store) apply(fs *FileSystem, changes map[string]*change) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := branchKey(fs.repoName, fs.branch)
+	var b *batch
+	for _, v := range s.batches[key] {
+		if v.client == fs.client && !v.flushing {
+			b = v
+			break
+		}
+	}
+	if b == nil {
+		b = &batch{
+			client:    fs.client,
+			repo:      fs.repo,
+			branch:    fs.branch,
+			committer: fs.committer,
+			changes:   make(map[string]*change),
+		}
+		s.batches[key] = append(s.batches[key], b)
+		b.timer = time.AfterFunc(s.idle, func() { s.flush(key, b) })
+	} else {
+		b.timer.Reset(s.idle)
+	}
+	for p, c := range changes {
+		b.changes[p] = c
+	}
+}
+
+// pending returns changes not yet saved to given repo branch.
+func (s *Store) pending(repoName, branch string) map[string]*change {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := make(map[string]*change)
+	for _, b := range s.batches[branchKey(repoName, branch)] {
+		for p, c := range b.changes {
+			if prev, ok := res[p]; !ok || !prev.when.After(c.when) {
+				res[p] = c
+			}
+		}
+	}
+	return res
+}
+
+func (s *Store) flush(key string, b *batch) {
+	s.lock.Lock()
+	if b.flushing {
+		s.lock.Unlock()
+		return
+	}
+	b.flushing = true
+	files := make([]pacakimpl.GitFile, 0, len(b.changes))
+	for p, c := range b.changes {
+		files = append(files, pacakimpl.GitFile{
+			Path:   strings.TrimPrefix(p, "/"),
+			Data:   c.data,
+			Delete: c.deleted,
+		})
+	}
+	s.lock.Unlock()
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	commit, err := b.repo.Save(b.committer, commitMessage(files), b.branch, b.branch, files)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case err == nil:
+	case rejected(err):
+		logrus.Errorf("WebDAV: dropped %v changes to branch %v: %v", len(files), b.branch, err)
+		s.remove(key, b)
+		return
+	case b.failures+1 >= maxSaveAttempts:
+		logrus.Errorf("WebDAV: dropped %v changes to branch %v after %v attempts: %v", len(files), b.branch, maxSaveAttempts, err)
+		s.remove(key, b)
+		return
+	default:
+		logrus.Errorf("WebDAV: failed save %v changes to branch %v: %v", len(files), b.branch, err)
+		s.retry(key, b)
+		return
+	}
+	logrus.Infof("WebDAV: saved %v changes to branch %v: %v", len(files), b.branch, commit)
+	s.remove(key, b)
+}
+
+// retry keeps changes of a failed batch and schedules another attempt
+// to save them. Changes overwritten by newer batches are dropped, the rest
+// are merged into a new batch of the same client if there is one.
+func (s *Store) retry(key string, b *batch) {
+	b.flushing = false
+	b.failures++
+	var next *batch
+	for _, v := range s.batches[key] {
+		if v == b {
+			continue
+		}
+		for p, c := range v.changes {
+			if prev, ok := b.changes[p]; ok && !prev.when.After(c.when) {
+				delete(b.changes, p)
+			}
+		}
+		if v.client == b.client && !v.flushing {
+			next = v
+		}
+	}
+	if next != nil {
+		for p, c := range b.changes {
+			next.changes[p] = c
+		}
+		if next.failures < b.failures {
+			next.failures = b.failures
+		}
+	}
+	if next != nil || len(b.changes) == 0 {
+		s.remove(key, b)
+		return
+	}
+	delay := s.idle << b.failures
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	logrus.Infof("WebDAV: retry saving changes to branch %v in %v", b.branch, delay)
+	b.timer = time.AfterFunc(delay, func() { s.flush(key, b) })
+}
+
+// rejected reports whether save failed because changes are not allowed,
+// so retrying it can not succeed.
+func rejected(err error) bool {
+	return errors.IsProtectedRef(err) ||
+		errors.IsValidationFailed(err) ||
+		errors.IsQuotaExceeded(err) ||
+		errors.IsBranchNotFound(err) ||
+		errors.IsRevisionNotFound(err) ||
+		errors.IsRepositoryNotFound(err) ||
+		errors.IsPathNotFound(err)
+}
+
+// remove deletes batch from pending batches of the branch.
+func (s *Store) remove(key string, b *batch) {
+	batches := s.batches[key]
+	for i := range batches {
+		if batches[i] == b {
+			batches = append(batches[:i], batches[i+1:]...)
+			break
+		}
+	}
+	if len(batches) == 0 {
+		delete(s.batches, key)
+	} else {
+		s.batches[key] = batches
+	}
+}
+
+func commitMessage(files []pacakimpl.GitFile) string {
+	var updated, deleted []string
+	for _, f := range files {
+		if f.Delete {
+			deleted = append(deleted, f.Path)
+		} else {
+			updated = append(updated, f.Path)
+		}
+	}
+	lines := []string{fmt.Sprintf("WebDAV: %v files updated, %v deleted", len(updated), len(deleted)), ""}
+	for _, p := range updated {
+		lines = append(lines, "Update "+p)
+	}
+	for _, p := range deleted {
+		lines = append(lines, "Delete "+p)
+	}
+	return strings.Join(lines, "\n")
+}
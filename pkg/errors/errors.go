@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type BranchAlreadyExists struct {
 	Name string
@@ -14,3 +17,397 @@ func IsBranchAlreadyExists(err error) bool {
 func (err BranchAlreadyExists) Error() string {
 	return fmt.Sprintf("branch already exists [name: %s]", err.Name)
 }
+
+type BranchNotFound struct {
+	Name string
+}
+
+func IsBranchNotFound(err error) bool {
+	_, ok := err.(BranchNotFound)
+	return ok
+}
+
+func (err BranchNotFound) Error() string {
+	return fmt.Sprintf("branch does not exist [name: %s]", err.Name)
+}
+
+type RevisionNotFound struct {
+	Rev string
+}
+
+func IsRevisionNotFound(err error) bool {
+	_, ok := err.(RevisionNotFound)
+	return ok
+}
+
+func (err RevisionNotFound) Error() string {
+	return fmt.Sprintf("revision does not exist [rev: %s]", err.Rev)
+}
+
+type NotFastForward struct {
+	Source string
+	Target string
+}
+
+func IsNotFastForward(err error) bool {
+	_, ok := err.(NotFastForward)
+	return ok
+}
+
+func (err NotFastForward) Error() string {
+	return fmt.Sprintf("not possible to fast-forward [source: %s, target: %s]", err.Source, err.Target)
+}
+
+// Conflict describes a path which can not be merged automatically.
+// Type is one of both-modified, both-added, both-deleted, added-by-us,
+// added-by-them, deleted-by-us, deleted-by-them.
+type Conflict struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type MergeConflict struct {
+	Source    string
+	Target    string
+	Conflicts []Conflict
+}
+
+func IsMergeConflict(err error) bool {
+	_, ok := err.(MergeConflict)
+	return ok
+}
+
+func (err MergeConflict) Error() string {
+	return fmt.Sprintf("merge conflict [source: %s, target: %s, paths: %d]", err.Source, err.Target, len(err.Conflicts))
+}
+
+type RefUpdateNotFound struct {
+	ID int64
+}
+
+func IsRefUpdateNotFound(err error) bool {
+	_, ok := err.(RefUpdateNotFound)
+	return ok
+}
+
+func (err RefUpdateNotFound) Error() string {
+	return fmt.Sprintf("ref update does not exist [id: %d]", err.ID)
+}
+
+type RefChanged struct {
+	Ref      string
+	Expected string
+	Actual   string
+}
+
+func IsRefChanged(err error) bool {
+	_, ok := err.(RefChanged)
+	return ok
+}
+
+func (err RefChanged) Error() string {
+	return fmt.Sprintf("ref has been changed [ref: %s, expected: %s, actual: %s]", err.Ref, err.Expected, err.Actual)
+}
+
+type PatchFailed struct {
+	Reason string
+}
+
+func IsPatchFailed(err error) bool {
+	_, ok := err.(PatchFailed)
+	return ok
+}
+
+func (err PatchFailed) Error() string {
+	return fmt.Sprintf("patch does not apply: %s", err.Reason)
+}
+
+type PathNotFound struct {
+	Rev  string
+	Path string
+}
+
+func IsPathNotFound(err error) bool {
+	_, ok := err.(PathNotFound)
+	return ok
+}
+
+func (err PathNotFound) Error() string {
+	return fmt.Sprintf("path does not exist [rev: %s, path: %s]", err.Rev, err.Path)
+}
+
+// Violation is a rule broken by changes rejected on push.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+type ValidationFailed struct {
+	Branch     string
+	Violations []Violation
+}
+
+func IsValidationFailed(err error) bool {
+	_, ok := err.(ValidationFailed)
+	return ok
+}
+
+func (err ValidationFailed) Error() string {
+	msgs := make([]string, 0, len(err.Violations))
+	for _, v := range err.Violations {
+		if v.Path != "" {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Message))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", v.Rule, v.Message))
+		}
+	}
+	return fmt.Sprintf("changes rejected by validation [branch: %s]: %s", err.Branch, strings.Join(msgs, "; "))
+}
+
+type ProtectedRef struct {
+	Ref    string
+	Reason string
+}
+
+func IsProtectedRef(err error) bool {
+	_, ok := err.(ProtectedRef)
+	return ok
+}
+
+func (err ProtectedRef) Error() string {
+	return fmt.Sprintf("ref is protected: %s [ref: %s]", err.Reason, err.Ref)
+}
+
+type MergeRequestNotFound struct {
+	ID int64
+}
+
+func IsMergeRequestNotFound(err error) bool {
+	_, ok := err.(MergeRequestNotFound)
+	return ok
+}
+
+func (err MergeRequestNotFound) Error() string {
+	return fmt.Sprintf("merge request does not exist [id: %d]", err.ID)
+}
+
+type MergeRequestNotOpen struct {
+	ID    int64
+	State string
+}
+
+func IsMergeRequestNotOpen(err error) bool {
+	_, ok := err.(MergeRequestNotOpen)
+	return ok
+}
+
+func (err MergeRequestNotOpen) Error() string {
+	return fmt.Sprintf("merge request is not open [id: %d, state: %s]", err.ID, err.State)
+}
+
+type NotEnoughApprovals struct {
+	ID       int64
+	Required int
+	Actual   int
+}
+
+func IsNotEnoughApprovals(err error) bool {
+	_, ok := err.(NotEnoughApprovals)
+	return ok
+}
+
+func (err NotEnoughApprovals) Error() string {
+	return fmt.Sprintf("merge request is not approved [id: %d, required: %d, actual: %d]", err.ID, err.Required, err.Actual)
+}
+
+type SelfApproval struct {
+	ID int64
+}
+
+func IsSelfApproval(err error) bool {
+	_, ok := err.(SelfApproval)
+	return ok
+}
+
+func (err SelfApproval) Error() string {
+	return fmt.Sprintf("author can not approve own merge request [id: %d]", err.ID)
+}
+
+type RepositoryNotFound struct {
+	Name string
+}
+
+func IsRepositoryNotFound(err error) bool {
+	_, ok := err.(RepositoryNotFound)
+	return ok
+}
+
+func (err RepositoryNotFound) Error() string {
+	return fmt.Sprintf("repository does not exist [name: %s]", err.Name)
+}
+
+type RepositoryAlreadyExists struct {
+	Name string
+}
+
+func IsRepositoryAlreadyExists(err error) bool {
+	_, ok := err.(RepositoryAlreadyExists)
+	return ok
+}
+
+func (err RepositoryAlreadyExists) Error() string {
+	return fmt.Sprintf("repository already exists [name: %s]", err.Name)
+}
+
+type TemplateNotFound struct {
+	Name string
+}
+
+func IsTemplateNotFound(err error) bool {
+	_, ok := err.(TemplateNotFound)
+	return ok
+}
+
+func (err TemplateNotFound) Error() string {
+	return fmt.Sprintf("repository template does not exist [name: %s]", err.Name)
+}
+
+type EmptyBundle struct {
+	Repo string
+}
+
+func IsEmptyBundle(err error) bool {
+	_, ok := err.(EmptyBundle)
+	return ok
+}
+
+func (err EmptyBundle) Error() string {
+	return fmt.Sprintf("nothing to bundle [repo: %s]", err.Repo)
+}
+
+type PushMirrorNotFound struct {
+	ID string
+}
+
+func IsPushMirrorNotFound(err error) bool {
+	_, ok := err.(PushMirrorNotFound)
+	return ok
+}
+
+func (err PushMirrorNotFound) Error() string {
+	return fmt.Sprintf("push mirror does not exist [id: %s]", err.ID)
+}
+
+type JobNotFound struct {
+	ID string
+}
+
+func IsJobNotFound(err error) bool {
+	_, ok := err.(JobNotFound)
+	return ok
+}
+
+func (err JobNotFound) Error() string {
+	return fmt.Sprintf("job does not exist [id: %s]", err.ID)
+}
+
+type JobFinished struct {
+	ID    string
+	State string
+}
+
+func IsJobFinished(err error) bool {
+	_, ok := err.(JobFinished)
+	return ok
+}
+
+func (err JobFinished) Error() string {
+	return fmt.Sprintf("job is already finished [id: %s, state: %s]", err.ID, err.State)
+}
+
+type JobConflict struct {
+	ID string
+}
+
+func IsJobConflict(err error) bool {
+	_, ok := err.(JobConflict)
+	return ok
+}
+
+func (err JobConflict) Error() string {
+	return fmt.Sprintf("job with different parameters is already active [id: %s]", err.ID)
+}
+
+type QuotaExceeded struct {
+	// Scope is either "repository" or "namespace".
+	Scope string
+	Name  string
+	Usage int64
+	Size  int64
+	Limit int64
+}
+
+func IsQuotaExceeded(err error) bool {
+	_, ok := err.(QuotaExceeded)
+	return ok
+}
+
+func (err QuotaExceeded) Error() string {
+	return fmt.Sprintf("disk quota exceeded [%s: %s, usage: %d, size: %d, limit: %d]", err.Scope, err.Name, err.Usage, err.Size, err.Limit)
+}
+
+type InvalidTemplatePath struct {
+	Name string
+	Path string
+}
+
+func IsInvalidTemplatePath(err error) bool {
+	_, ok := err.(InvalidTemplatePath)
+	return ok
+}
+
+func (err InvalidTemplatePath) Error() string {
+	return fmt.Sprintf("template file path is outside of repository [name: %s, path: %s]", err.Name, err.Path)
+}
+
+type RepositoryNotEmpty struct {
+	Name string
+}
+
+func IsRepositoryNotEmpty(err error) bool {
+	_, ok := err.(RepositoryNotEmpty)
+	return ok
+}
+
+func (err RepositoryNotEmpty) Error() string {
+	return fmt.Sprintf("repository is not empty [name: %s]", err.Name)
+}
+
+type InvalidSearchQuery struct {
+	Query  string
+	Reason string
+}
+
+func IsInvalidSearchQuery(err error) bool {
+	_, ok := err.(InvalidSearchQuery)
+	return ok
+}
+
+func (err InvalidSearchQuery) Error() string {
+	return fmt.Sprintf("invalid search query [query: %s, reason: %s]", err.Query, err.Reason)
+}
+
+type AlreadyUpToDate struct {
+	Source string
+	Target string
+}
+
+func IsAlreadyUpToDate(err error) bool {
+	_, ok := err.(AlreadyUpToDate)
+	return ok
+}
+
+func (err AlreadyUpToDate) Error() string {
+	return fmt.Sprintf("target already contains all changes of source [source: %s, target: %s]", err.Source, err.Target)
+}
@@ -0,0 +1,240 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// subscriberBuffer is the number of events a slow subscriber may lag
+// behind before it is disconnected.
+const subscriberBuffer = 64
+
+// Event is a ref update of a repository. IDs grow monotonically
+// and survive restarts, so clients can resume the stream.
+type Event struct {
+	ID        int64     `json:"id"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Action    string    `json:"action"`
+	Committer string    `json:"committer,omitempty"`
+	When      time.Time `json:"when"`
+}
+
+// Filter selects events by repository and ref. Repo is a repository
+// name or a namespace ending with '/'. Ref is a ref name or a pattern
+// like 'refs/heads/*'. Empty fields match everything.
+type Filter struct {
+	Repo string
+	Ref  string
+}
+
+func (f Filter) Match(e Event) bool {
+	if f.Repo != "" && e.Repo != f.Repo && !(strings.HasSuffix(f.Repo, "/") && strings.HasPrefix(e.Repo, f.Repo)) {
+		return false
+	}
+	if f.Ref != "" && e.Ref != f.Ref {
+		if ok, _ := path.Match(f.Ref, e.Ref); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	ch     chan Event
+}
+
+// Broker keeps recent ref update events and fans them out to subscribers.
+// Events are appended to a file, so recent history is kept across restarts.
+type Broker struct {
+	filePath string
+	size     int
+
+	lock   sync.Mutex
+	file   *os.File
+	lines  int
+	events []Event
+	nextID int64
+	subs   map[*subscriber]bool
+}
+
+// NewBroker loads recent events from filePath, keeps up to size last
+// events and subscribes to ref updates of git.
+func NewBroker(filePath string, size int, gitInterface pacakimpl.GitInterface) (*Broker, error) {
+	b := &Broker{
+		filePath: filePath,
+		size:     size,
+		nextID:   1,
+		subs:     make(map[*subscriber]bool),
+	}
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	if err := b.compact(); err != nil {
+		return nil, err
+	}
+	gitInterface.OnRefUpdate(b.RefUpdated)
+	return b, nil
+}
+
+func (b *Broker) load() error {
+	f, err := os.Open(b.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := Event{}
+		// Last line may be incomplete after crash.
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		b.add(e)
+	}
+	return scanner.Err()
+}
+
+func (b *Broker) add(e Event) {
+	b.events = append(b.events, e)
+	if len(b.events) > b.size {
+		b.events = b.events[len(b.events)-b.size:]
+	}
+	if e.ID >= b.nextID {
+		b.nextID = e.ID + 1
+	}
+}
+
+// compact rewrites the file with kept events only and reopens it for appending.
+func (b *Broker) compact() error {
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+	tmp := b.filePath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range b.events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, b.filePath); err != nil {
+		return err
+	}
+	b.file, err = os.OpenFile(b.filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	b.lines = len(b.events)
+	return err
+}
+
+// RefUpdated publishes the ref update to subscribers.
+func (b *Broker) RefUpdated(repo string, u pacakimpl.RefUpdate) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	e := Event{
+		ID:        b.nextID,
+		Repo:      repo,
+		Ref:       u.Ref,
+		Old:       u.Old,
+		New:       u.New,
+		Action:    u.Action,
+		Committer: u.Committer,
+		When:      u.When,
+	}
+	b.add(e)
+	if err := b.persist(e); err != nil {
+		logrus.Errorf("Events: failed persist event %v: %v", e.ID, err)
+	}
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			// Subscriber is too slow, it has to reconnect and resume.
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+func (b *Broker) persist(e Event) error {
+	if b.lines >= 2*b.size {
+		if err := b.compact(); err != nil {
+			return err
+		}
+	}
+	if b.file == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	b.lines++
+	return nil
+}
+
+// Subscribe returns events after given ID matching the filter and a channel
+// of new events. Complete is false if some events after the ID are not kept
+// anymore. The channel is closed if subscriber does not keep up with events;
+// cancel must be called once the subscriber is done.
+func (b *Broker) Subscribe(after int64, filter Filter) (backlog []Event, complete bool, ch <-chan Event, cancel func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	complete = true
+	if after >= 0 && len(b.events) > 0 && b.events[0].ID > after+1 {
+		complete = false
+	}
+	backlog = []Event{}
+	if after >= 0 {
+		for _, e := range b.events {
+			if e.ID > after && filter.Match(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+	s := &subscriber{filter: filter, ch: make(chan Event, subscriberBuffer)}
+	b.subs[s] = true
+	cancel = func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.subs[s] {
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return backlog, complete, s.ch, cancel
+}
@@ -0,0 +1,384 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// docsBucket maintains documents keyed by 'repo\x00commit'.
+	docsBucket = []byte("docs")
+	// termsBucket maintains nested bucket per term with keys of documents containing it.
+	termsBucket = []byte("terms")
+	// refsBucket maintains indexed value of every ref keyed by 'repo\x00ref'.
+	refsBucket = []byte("refs")
+)
+
+// Document is an indexed commit.
+type Document struct {
+	Repo        string    `json:"repo"`
+	Commit      string    `json:"commit"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	When        time.Time `json:"when"`
+	Message     string    `json:"message"`
+	Tags        []string  `json:"tags,omitempty"`
+	Paths       []string  `json:"paths,omitempty"`
+}
+
+func (d *Document) key() []byte {
+	return docKey(d.Repo, d.Commit)
+}
+
+// terms returns distinct terms the document is found by.
+func (d *Document) terms() map[string]bool {
+	res := map[string]bool{}
+	add := func(s string) {
+		for _, t := range tokenize(s) {
+			res[t] = true
+		}
+	}
+	add(d.Repo)
+	add(d.AuthorName)
+	add(d.AuthorEmail)
+	add(d.Message)
+	for _, t := range d.Tags {
+		add(t)
+	}
+	for _, p := range d.Paths {
+		add(p)
+	}
+	// Full commit ID and its short form.
+	res[d.Commit] = true
+	if len(d.Commit) > 7 {
+		res[d.Commit[:7]] = true
+	}
+	return res
+}
+
+func docKey(repo, commit string) []byte {
+	return []byte(repo + "\x00" + commit)
+}
+
+// tokenize splits text into lower-cased terms made of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Index is an on-disk index of commits across repositories.
+type Index struct {
+	db *bolt.DB
+}
+
+// Open opens index stored in the file at given path, creating it if needed.
+func Open(filePath string) (*Index, error) {
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{docsBucket, termsBucket, refsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Index{db: db}, nil
+}
+
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+func getDoc(tx *bolt.Tx, key []byte) (*Document, error) {
+	data := tx.Bucket(docsBucket).Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	doc := &Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// putDoc stores the document and updates its terms. Old is the stored
+// version of the document or nil.
+func putDoc(tx *bolt.Tx, doc, old *Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	key := doc.key()
+	if err := tx.Bucket(docsBucket).Put(key, data); err != nil {
+		return err
+	}
+	terms := tx.Bucket(termsBucket)
+	newTerms := doc.terms()
+	if old != nil {
+		for t := range old.terms() {
+			if !newTerms[t] {
+				if err := removeTerm(terms, t, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for t := range newTerms {
+		b, err := terms.CreateBucketIfNotExists([]byte(t))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteDoc(tx *bolt.Tx, doc *Document) error {
+	key := doc.key()
+	terms := tx.Bucket(termsBucket)
+	for t := range doc.terms() {
+		if err := removeTerm(terms, t, key); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(docsBucket).Delete(key)
+}
+
+func removeTerm(terms *bolt.Bucket, term string, key []byte) error {
+	b := terms.Bucket([]byte(term))
+	if b == nil {
+		return nil
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	if k, _ := b.Cursor().First(); k == nil {
+		return terms.DeleteBucket([]byte(term))
+	}
+	return nil
+}
+
+// Add indexes documents which are not indexed yet.
+func (i *Index) Add(docs []Document) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		for j := range docs {
+			if tx.Bucket(docsBucket).Get(docs[j].key()) != nil {
+				continue
+			}
+			if err := putDoc(tx, &docs[j], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetTag moves tag of the repository from commit old to commit new.
+// Empty old or new value means tag is added or removed respectively.
+func (i *Index) SetTag(repo, tag, old, new string) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		update := func(commit string, f func(doc *Document)) error {
+			doc, err := getDoc(tx, docKey(repo, commit))
+			if err != nil || doc == nil {
+				return err
+			}
+			prev := *doc
+			prev.Tags = append([]string{}, doc.Tags...)
+			f(doc)
+			return putDoc(tx, doc, &prev)
+		}
+		if old != "" {
+			err := update(old, func(doc *Document) {
+				tags := []string{}
+				for _, t := range doc.Tags {
+					if t != tag {
+						tags = append(tags, t)
+					}
+				}
+				doc.Tags = tags
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if new != "" {
+			return update(new, func(doc *Document) {
+				for _, t := range doc.Tags {
+					if t == tag {
+						return
+					}
+				}
+				doc.Tags = append(doc.Tags, tag)
+				sort.Strings(doc.Tags)
+			})
+		}
+		return nil
+	})
+}
+
+// Refs returns indexed values of refs of the repository.
+func (i *Index) Refs(repo string) (map[string]string, error) {
+	refs := map[string]string{}
+	prefix := []byte(repo + "\x00")
+	err := i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(refsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			refs[string(k[len(prefix):])] = string(v)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// SetRef records indexed value of the ref. Empty value removes the ref.
+func (i *Index) SetRef(repo, ref, value string) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(repo + "\x00" + ref)
+		if value == "" {
+			return tx.Bucket(refsBucket).Delete(key)
+		}
+		return tx.Bucket(refsBucket).Put(key, []byte(value))
+	})
+}
+
+// Repositories returns names of all indexed repositories.
+func (i *Index) Repositories() ([]string, error) {
+	repos := []string{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(docsBucket).Cursor()
+		for k, _ := c.First(); k != nil; {
+			repo := string(k[:bytes.IndexByte(k, 0)])
+			repos = append(repos, repo)
+			// Skip the rest of repository documents.
+			k, _ = c.Seek([]byte(repo + "\x01"))
+		}
+		return nil
+	})
+	return repos, err
+}
+
+// DeleteRepository removes all documents and refs of the repository.
+func (i *Index) DeleteRepository(repo string) error {
+	prefix := []byte(repo + "\x00")
+	return i.db.Update(func(tx *bolt.Tx) error {
+		docs := []*Document{}
+		c := tx.Bucket(docsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			doc := &Document{}
+			if err := json.Unmarshal(v, doc); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+		}
+		for _, doc := range docs {
+			if err := deleteDoc(tx, doc); err != nil {
+				return err
+			}
+		}
+		refs := tx.Bucket(refsBucket)
+		keys := [][]byte{}
+		c = refs.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := refs.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type SearchOptions struct {
+	// Repo limits search to the repository or to all repositories
+	// of the namespace if it ends with '/'.
+	Repo  string
+	Limit int
+}
+
+// Search returns documents containing all terms of the query, newest first.
+func (i *Index) Search(query string, opts SearchOptions) ([]Document, error) {
+	res := []Document{}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return res, nil
+	}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		// Start with the rarest term to keep intersection small.
+		buckets := make([]*bolt.Bucket, 0, len(terms))
+		for _, t := range terms {
+			b := tx.Bucket(termsBucket).Bucket([]byte(t))
+			if b == nil {
+				return nil
+			}
+			buckets = append(buckets, b)
+		}
+		sort.Slice(buckets, func(a, b int) bool {
+			return buckets[a].Stats().KeyN < buckets[b].Stats().KeyN
+		})
+		c := buckets[0].Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if !matchRepo(k, opts.Repo) {
+				continue
+			}
+			found := true
+			for _, b := range buckets[1:] {
+				if b.Get(k) == nil {
+					found = false
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+			doc, err := getDoc(tx, k)
+			if err != nil {
+				return err
+			}
+			if doc != nil {
+				res = append(res, *doc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].When.After(res[b].When) })
+	if opts.Limit > 0 && len(res) > opts.Limit {
+		res = res[:opts.Limit]
+	}
+	return res, nil
+}
+
+func matchRepo(key []byte, repo string) bool {
+	if repo == "" {
+		return true
+	}
+	if strings.HasSuffix(repo, "/") {
+		return bytes.HasPrefix(key, []byte(repo))
+	}
+	return bytes.HasPrefix(key, []byte(repo+"\x00"))
+}
@@ -0,0 +1,180 @@
+package index
+
+import (
+	"strings"
+	"sync"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// Indexer keeps Index up to date with refs of repositories. Ref updates
+// are queued and indexed in background.
+type Indexer struct {
+	*Index
+	git pacakimpl.GitInterface
+
+	lock    sync.Mutex
+	queue   []refUpdate
+	pending chan struct{}
+}
+
+type refUpdate struct {
+	repo string
+	ref  string
+	// full reindexes all refs of the repository.
+	full bool
+}
+
+// NewIndexer creates Indexer of repositories managed by git and starts
+// background indexing. All repositories are reindexed on start.
+func NewIndexer(index *Index, gitInterface pacakimpl.GitInterface) *Indexer {
+	i := &Indexer{
+		Index:   index,
+		git:     gitInterface,
+		pending: make(chan struct{}, 1),
+	}
+	gitInterface.OnRefUpdate(i.RefUpdated)
+	go i.run()
+	go i.reindexAll()
+	return i
+}
+
+// RefUpdated queues indexing of the updated ref.
+func (i *Indexer) RefUpdated(repo string, u pacakimpl.RefUpdate) {
+	i.enqueue(refUpdate{repo: repo, ref: u.Ref})
+}
+
+func (i *Indexer) enqueue(u refUpdate) {
+	i.lock.Lock()
+	i.queue = append(i.queue, u)
+	i.lock.Unlock()
+	select {
+	case i.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (i *Indexer) run() {
+	for range i.pending {
+		for {
+			i.lock.Lock()
+			if len(i.queue) == 0 {
+				i.lock.Unlock()
+				break
+			}
+			u := i.queue[0]
+			i.queue = i.queue[1:]
+			i.lock.Unlock()
+
+			if err := i.indexRepo(u); err != nil {
+				logrus.Errorf("Index: failed index %v: %v", u.repo, err)
+			}
+		}
+	}
+}
+
+// reindexAll queues all repositories and drops index of deleted ones.
+func (i *Indexer) reindexAll() {
+	repos, err := i.git.ListRepositories()
+	if err != nil {
+		logrus.Errorf("Index: failed list repositories: %v", err)
+		return
+	}
+	exists := map[string]bool{}
+	for _, repo := range repos {
+		exists[repo] = true
+		i.enqueue(refUpdate{repo: repo, full: true})
+	}
+	indexed, err := i.Repositories()
+	if err != nil {
+		logrus.Errorf("Index: failed list indexed repositories: %v", err)
+		return
+	}
+	for _, repo := range indexed {
+		if !exists[repo] {
+			if err := i.DeleteRepository(repo); err != nil {
+				logrus.Errorf("Index: failed delete %v: %v", repo, err)
+			}
+		}
+	}
+}
+
+// indexRepo indexes changes of the updated ref, or of all refs if u.full is set.
+func (i *Indexer) indexRepo(u refUpdate) error {
+	if !i.git.ExistsRepository(u.repo) {
+		return i.DeleteRepository(u.repo)
+	}
+	repo, err := i.git.GetRepository(u.repo)
+	if err != nil {
+		return err
+	}
+	current, err := repo.Refs()
+	if err != nil {
+		return err
+	}
+	indexed, err := i.Refs(u.repo)
+	if err != nil {
+		return err
+	}
+	refs := map[string]bool{u.ref: true}
+	if u.full {
+		refs = map[string]bool{}
+		for ref := range current {
+			refs[ref] = true
+		}
+		for ref := range indexed {
+			refs[ref] = true
+		}
+	}
+	for ref := range refs {
+		if current[ref] == indexed[ref] {
+			continue
+		}
+		if err := i.indexRef(u.repo, repo, ref, indexed[ref], current[ref]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRef indexes commits which became reachable from the ref
+// when it moved from commit old to commit new.
+func (i *Indexer) indexRef(repoName string, repo pacakimpl.PacakRepo, ref, old, new string) error {
+	if new != "" {
+		exclude := []string{}
+		if old != "" {
+			exclude = append(exclude, old)
+		}
+		commits, err := repo.LogChanges(new, exclude...)
+		if err != nil && old != "" {
+			// Old commit may be gone, index full history then.
+			commits, err = repo.LogChanges(new)
+		}
+		if err != nil {
+			return err
+		}
+		docs := make([]Document, 0, len(commits))
+		for _, c := range commits {
+			docs = append(docs, Document{
+				Repo:        repoName,
+				Commit:      c.ID,
+				AuthorName:  c.AuthorName,
+				AuthorEmail: c.AuthorEmail,
+				When:        c.When,
+				Message:     c.Message,
+				Paths:       c.Paths,
+			})
+		}
+		if err := i.Add(docs); err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(ref, git.TAG_PREFIX) {
+		if err := i.SetTag(repoName, strings.TrimPrefix(ref, git.TAG_PREFIX), old, new); err != nil {
+			return err
+		}
+	}
+	return i.SetRef(repoName, ref, new)
+}
@@ -0,0 +1,429 @@
+// Package jobs runs long repository operations in background.
+//
+// Jobs are queued and run by a fixed number of workers. Job state is
+// stored under the manager directory, so queued jobs survive restarts;
+// jobs running at restart are marked failed. Git commands started by a
+// job with its context are attributed to the job in pkg/process and are
+// killed when the job is cancelled.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+	"github.com/sirupsen/logrus"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+
+	// keepJobs is number of finished jobs kept.
+	keepJobs = 1000
+	// progressInterval limits how often progress is persisted.
+	progressInterval = time.Second
+)
+
+// Job is a background operation on a repository.
+type Job struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Repo  string `json:"repo,omitempty"`
+	State State  `json:"state"`
+	// Progress is the percentage of completed work.
+	Progress int    `json:"progress"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Params are passed to the job handler.
+	Params json.RawMessage `json:"params,omitempty"`
+	// Result is set by the job handler on success.
+	Result json.RawMessage `json:"result,omitempty"`
+	// Files are temporary files owned by the job, removed when it finishes.
+	Files    []string   `json:"-"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Done reports whether job is finished.
+func (j *Job) Done() bool {
+	return j.State == StateSucceeded || j.State == StateFailed || j.State == StateCancelled
+}
+
+// storedJob keeps files of the job on disk.
+type storedJob struct {
+	*Job
+	Files []string `json:"files,omitempty"`
+}
+
+// Handler runs job. Params are the ones job is submitted with. Returned
+// result is stored as job result. Handler must stop when ctx is done.
+type Handler func(ctx *Context, params json.RawMessage) (interface{}, error)
+
+// Context is passed to job handlers.
+type Context struct {
+	context.Context
+	job *Job
+	m   *Manager
+
+	lastSave time.Time
+}
+
+// Job returns copy of the running job.
+func (c *Context) Job() Job {
+	c.m.lock.Lock()
+	defer c.m.lock.Unlock()
+	return *c.job
+}
+
+// SetProgress updates percentage of completed work and the status message.
+func (c *Context) SetProgress(progress int, message string) {
+	c.m.lock.Lock()
+	defer c.m.lock.Unlock()
+	c.job.Progress = progress
+	c.job.Message = message
+	if time.Since(c.lastSave) >= progressInterval {
+		c.lastSave = time.Now()
+		c.m.save(c.job)
+	}
+}
+
+// Manager queues and runs jobs.
+type Manager struct {
+	dir      string
+	workers  int
+	handlers map[string]Handler
+
+	submitLock sync.Mutex
+
+	lock sync.Mutex
+	// queue holds IDs of queued jobs in order they run. Workers wait
+	// on queued for new jobs.
+	queue   []string
+	queued  *sync.Cond
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	started bool
+}
+
+// NewManager loads jobs stored in dir. Jobs are run once Start is called.
+func NewManager(dir string, workers int) (*Manager, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		dir:      dir,
+		workers:  workers,
+		handlers: make(map[string]Handler),
+		jobs:     make(map[string]*Job),
+		cancels:  make(map[string]context.CancelFunc),
+	}
+	m.queued = sync.NewCond(&m.lock)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s := storedJob{Job: &Job{}}
+		if err := json.Unmarshal(data, &s); err != nil {
+			logrus.Errorf("Jobs: failed read %v: %v", f.Name(), err)
+			continue
+		}
+		j := s.Job
+		j.Files = s.Files
+		if j.State == StateRunning {
+			now := time.Now()
+			j.State = StateFailed
+			j.Error = "interrupted by restart"
+			j.Finished = &now
+			m.finish(j)
+		}
+		m.jobs[j.ID] = j
+	}
+	return m, nil
+}
+
+// Dir returns directory jobs are stored in. Jobs may keep their files there.
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Register sets handler of jobs of the type. Handlers must be registered before Start.
+func (m *Manager) Register(typ string, h Handler) {
+	m.handlers[typ] = h
+}
+
+// Start starts workers and queues jobs left queued before restart.
+func (m *Manager) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.started {
+		return
+	}
+	m.started = true
+	queued := []*Job{}
+	for _, j := range m.jobs {
+		if j.State == StateQueued {
+			queued = append(queued, j)
+		}
+	}
+	sort.Slice(queued, func(i, k int) bool { return queued[i].Created.Before(queued[k].Created) })
+	for _, j := range queued {
+		m.queue = append(m.queue, j.ID)
+	}
+	for i := 0; i < m.workers; i++ {
+		go m.worker()
+	}
+}
+
+// Submit queues job of the type with params. Files are removed when the job finishes.
+func (m *Manager) Submit(typ, repo string, params interface{}, files ...string) (Job, error) {
+	if _, ok := m.handlers[typ]; !ok {
+		return Job{}, fmt.Errorf("Unknown job type '%s'", typ)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, err
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	j := &Job{
+		ID:      hex.EncodeToString(b),
+		Type:    typ,
+		Repo:    repo,
+		State:   StateQueued,
+		Params:  data,
+		Files:   files,
+		Created: time.Now(),
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.save(j); err != nil {
+		return Job{}, err
+	}
+	m.jobs[j.ID] = j
+	if m.started {
+		m.queue = append(m.queue, j.ID)
+		m.queued.Signal()
+	}
+	m.trim()
+	return *j, nil
+}
+
+// SubmitOnce is like Submit but returns queued or running job of the
+// type for repo if there is one. JobConflict is returned if params of
+// that job differ.
+func (m *Manager) SubmitOnce(typ, repo string, params interface{}) (Job, error) {
+	m.submitLock.Lock()
+	defer m.submitLock.Unlock()
+	if j, ok := m.Active(typ, repo); ok {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return Job{}, err
+		}
+		if !bytes.Equal(data, j.Params) {
+			return Job{}, errors.JobConflict{ID: j.ID}
+		}
+		return j, nil
+	}
+	return m.Submit(typ, repo, params)
+}
+
+// Get returns job by ID.
+func (m *Manager) Get(id string) (Job, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errors.JobNotFound{ID: id}
+	}
+	return *j, nil
+}
+
+// List returns jobs matching non-empty type, repo and state, newest first.
+func (m *Manager) List(typ, repo string, state State) []Job {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := []Job{}
+	for _, j := range m.jobs {
+		if (typ == "" || j.Type == typ) && (repo == "" || j.Repo == repo) && (state == "" || j.State == state) {
+			res = append(res, *j)
+		}
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Created.After(res[k].Created) })
+	return res
+}
+
+// Active returns queued or running job of the type for repo, if any.
+func (m *Manager) Active(typ, repo string) (Job, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, j := range m.jobs {
+		if j.Type == typ && j.Repo == repo && !j.Done() {
+			return *j, true
+		}
+	}
+	return Job{}, false
+}
+
+// Cancel cancels queued or running job. Running git commands of the job are killed.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errors.JobNotFound{ID: id}
+	}
+	switch {
+	case j.Done():
+		return *j, errors.JobFinished{ID: id, State: string(j.State)}
+	case j.State == StateQueued:
+		now := time.Now()
+		j.State = StateCancelled
+		j.Finished = &now
+		m.finish(j)
+	default:
+		// Worker marks job cancelled once handler returns.
+		m.cancels[id]()
+	}
+	return *j, nil
+}
+
+func (m *Manager) worker() {
+	for {
+		m.lock.Lock()
+		for len(m.queue) == 0 {
+			m.queued.Wait()
+		}
+		id := m.queue[0]
+		m.queue = m.queue[1:]
+		j, ok := m.jobs[id]
+		if !ok || j.State != StateQueued {
+			m.lock.Unlock()
+			continue
+		}
+		now := time.Now()
+		j.State = StateRunning
+		j.Started = &now
+		ctx, cancel := context.WithCancel(process.WithJob(context.Background(), id))
+		m.cancels[id] = cancel
+		m.save(j)
+		params := j.Params
+		h := m.handlers[j.Type]
+		m.lock.Unlock()
+
+		jctx := &Context{Context: ctx, job: j, m: m, lastSave: now}
+		var result interface{}
+		var err error
+		if h == nil {
+			err = fmt.Errorf("Unknown job type '%s'", j.Type)
+		} else {
+			result, err = m.run(h, jctx, params)
+		}
+
+		m.lock.Lock()
+		now = time.Now()
+		j.Finished = &now
+		switch {
+		case ctx.Err() != nil:
+			j.State = StateCancelled
+		case err != nil:
+			j.State = StateFailed
+			j.Error = err.Error()
+		default:
+			j.State = StateSucceeded
+			j.Progress = 100
+			if result != nil {
+				if data, err := json.Marshal(result); err == nil {
+					j.Result = data
+				}
+			}
+		}
+		delete(m.cancels, id)
+		cancel()
+		m.finish(j)
+		m.lock.Unlock()
+		if err != nil && j.State == StateFailed {
+			logrus.Errorf("Jobs: %v %v of %v failed: %v", j.Type, j.ID, j.Repo, err)
+		}
+	}
+}
+
+// run calls handler turning panics into job failures.
+func (m *Manager) run(h Handler, ctx *Context, params json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, params)
+}
+
+// finish removes files of finished job and saves it. Must be called with lock held.
+func (m *Manager) finish(j *Job) {
+	for _, f := range j.Files {
+		os.Remove(f)
+	}
+	j.Files = nil
+	m.save(j)
+}
+
+// save writes job to disk. Must be called with lock held.
+func (m *Manager) save(j *Job) error {
+	data, err := json.Marshal(storedJob{Job: j, Files: j.Files})
+	if err != nil {
+		return err
+	}
+	filePath := path.Join(m.dir, j.ID+".json")
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err == nil {
+		err = os.Rename(tmp, filePath)
+	}
+	if err != nil {
+		logrus.Errorf("Jobs: failed save %v: %v", j.ID, err)
+	}
+	return err
+}
+
+// trim forgets oldest finished jobs. Must be called with lock held.
+func (m *Manager) trim() {
+	if len(m.jobs) <= keepJobs {
+		return
+	}
+	done := []*Job{}
+	for _, j := range m.jobs {
+		if j.Done() {
+			done = append(done, j)
+		}
+	}
+	sort.Slice(done, func(i, k int) bool { return done[i].Created.Before(done[k].Created) })
+	for i := 0; i < len(done) && len(m.jobs) > keepJobs; i++ {
+		delete(m.jobs, done[i].ID)
+		os.Remove(path.Join(m.dir, done[i].ID+".json"))
+	}
+}
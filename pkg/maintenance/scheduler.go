@@ -0,0 +1,154 @@
+// Package maintenance periodically runs gc, repack, commit-graph and fsck
+// on repositories which need them.
+package maintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// Job is the type of maintenance jobs.
+const Job = "maintenance"
+
+// Policy tells which maintenance tasks repository needs.
+type Policy struct {
+	// LooseObjects is the number of loose objects gc runs at.
+	LooseObjects int
+	// Packs is the number of packs objects are repacked into a single pack at.
+	Packs int
+	// FsckInterval is time between fsck runs. Fsck is disabled if zero.
+	FsckInterval time.Duration
+}
+
+// Tasks returns maintenance tasks needed by repository with stats and status.
+func (p Policy) Tasks(stats *pacakimpl.ObjectStats, status *pacakimpl.MaintenanceStatus, now time.Time) []string {
+	tasks := []string{}
+	switch {
+	case p.LooseObjects > 0 && stats.Loose >= p.LooseObjects:
+		// gc repacks as well.
+		tasks = append(tasks, pacakimpl.TaskGC, pacakimpl.TaskCommitGraph)
+	case p.Packs > 0 && stats.Packs >= p.Packs:
+		tasks = append(tasks, pacakimpl.TaskRepack, pacakimpl.TaskCommitGraph)
+	}
+	if p.FsckInterval > 0 && (status.LastFsck == nil || now.Sub(*status.LastFsck) >= p.FsckInterval) {
+		tasks = append(tasks, pacakimpl.TaskFsck)
+	}
+	return tasks
+}
+
+type jobParams struct {
+	Tasks []string `json:"tasks"`
+}
+
+// Scheduler checks repositories and runs maintenance jobs for them.
+type Scheduler struct {
+	git    pacakimpl.GitInterface
+	jobs   *jobs.Manager
+	policy Policy
+}
+
+// NewScheduler starts checking all repositories every interval. Repositories
+// are checked only on demand if interval is zero.
+func NewScheduler(git pacakimpl.GitInterface, jobManager *jobs.Manager, policy Policy, interval time.Duration) *Scheduler {
+	s := &Scheduler{git: git, jobs: jobManager, policy: policy}
+	jobManager.Register(Job, s.run)
+	if interval > 0 {
+		go func() {
+			for {
+				time.Sleep(interval)
+				s.checkAll()
+			}
+		}()
+	}
+	return s
+}
+
+// Policy returns the policy maintenance tasks are chosen by.
+func (s *Scheduler) Policy() Policy {
+	return s.policy
+}
+
+func (s *Scheduler) checkAll() {
+	repos, err := s.git.ListRepositories()
+	if err != nil {
+		logrus.Errorf("Maintenance: failed list repositories: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, repo := range repos {
+		r, err := s.git.GetRepository(repo)
+		if err != nil {
+			continue
+		}
+		stats, err := r.ObjectStats()
+		if err != nil {
+			logrus.Errorf("Maintenance: %v: %v", repo, err)
+			continue
+		}
+		status, err := r.MaintenanceStatus()
+		if err != nil {
+			logrus.Errorf("Maintenance: %v: %v", repo, err)
+			continue
+		}
+		if tasks := s.policy.Tasks(stats, status, now); len(tasks) > 0 {
+			// Other maintenance of the repo is active, check again next time.
+			if _, err := s.Trigger(repo, tasks); err != nil && !errors.IsJobConflict(err) {
+				logrus.Errorf("Maintenance: %v: %v", repo, err)
+			}
+		}
+	}
+}
+
+// Trigger queues maintenance of repo with tasks. The job already queued
+// or running with the same tasks is returned if there is one, JobConflict
+// is returned if it runs other tasks.
+func (s *Scheduler) Trigger(repo string, tasks []string) (jobs.Job, error) {
+	if len(tasks) == 0 {
+		return jobs.Job{}, fmt.Errorf("No maintenance tasks given")
+	}
+	// Tasks are always run in the same order, so params of jobs with
+	// the same tasks are equal.
+	unique := map[string]bool{}
+	params := jobParams{}
+	for _, t := range tasks {
+		if !pacakimpl.IsMaintenanceTask(t) {
+			return jobs.Job{}, fmt.Errorf("Unknown maintenance task '%s'", t)
+		}
+		if !unique[t] {
+			unique[t] = true
+			params.Tasks = append(params.Tasks, t)
+		}
+	}
+	sort.Strings(params.Tasks)
+	return s.jobs.SubmitOnce(Job, repo, params)
+}
+
+func (s *Scheduler) run(ctx *jobs.Context, data json.RawMessage) (interface{}, error) {
+	params := jobParams{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	repo := ctx.Job().Repo
+	r, err := s.git.GetRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx.SetProgress(0, fmt.Sprintf("running %v", params.Tasks))
+	status, err := r.Maintain(ctx, params.Tasks)
+	switch {
+	case status != nil && status.Corrupt:
+		logrus.Errorf("Maintenance: %v is corrupt: %v", repo, status.Problems)
+	case err != nil:
+		logrus.Errorf("Maintenance: %v failed: %v", repo, err)
+	default:
+		logrus.Infof("Maintenance: %v done: %v", repo, params.Tasks)
+	}
+	return status, err
+}
@@ -0,0 +1,155 @@
+package mirror
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxPushAttempts is the number of failed pushes after which retries
+	// stop until refs change again or push is triggered.
+	maxPushAttempts = 10
+	// maxPushes is the number of push mirrors pushed at once.
+	maxPushes      = 4
+	pushRetryDelay = 10 * time.Second
+	maxPushDelay   = time.Hour
+)
+
+// Pusher pushes changed refs to push mirrors in background.
+type Pusher struct {
+	git   pacakimpl.GitInterface
+	slots chan struct{}
+
+	lock    sync.Mutex
+	running map[string]bool
+	// dirty marks running pushes triggered again, they are repeated once done.
+	dirty map[string]bool
+}
+
+// NewPusher starts pushing to push mirrors after ref updates. Push
+// mirrors left pending before restart are pushed immediately.
+func NewPusher(git pacakimpl.GitInterface) *Pusher {
+	p := &Pusher{
+		git:     git,
+		slots:   make(chan struct{}, maxPushes),
+		running: make(map[string]bool),
+		dirty:   make(map[string]bool),
+	}
+	git.OnRefUpdate(func(repo string, _ pacakimpl.RefUpdate) {
+		go p.refsChanged(repo)
+	})
+	go p.resume()
+	return p
+}
+
+func (p *Pusher) refsChanged(repo string) {
+	r, err := p.git.GetRepository(repo)
+	if err != nil {
+		return
+	}
+	ids, err := r.SetPushMirrorsPending()
+	if err != nil {
+		logrus.Errorf("Push mirror: %v: %v", repo, err)
+		return
+	}
+	for _, id := range ids {
+		p.Trigger(repo, id)
+	}
+}
+
+func (p *Pusher) resume() {
+	repos, err := p.git.ListRepositories()
+	if err != nil {
+		logrus.Errorf("Push mirror: failed list repositories: %v", err)
+		return
+	}
+	for _, repo := range repos {
+		r, err := p.git.GetRepository(repo)
+		if err != nil {
+			continue
+		}
+		mirrors, err := r.PushMirrors()
+		if err != nil {
+			logrus.Errorf("Push mirror: %v: %v", repo, err)
+			continue
+		}
+		for _, m := range mirrors {
+			if m.Pending && m.Attempts < maxPushAttempts {
+				p.Trigger(repo, m.ID)
+			}
+		}
+	}
+}
+
+// Trigger starts pushing to push mirror id of repo in background. It
+// returns false if push is already running, the running push is then
+// repeated once it is done.
+func (p *Pusher) Trigger(repo, id string) bool {
+	key := repo + "\x00" + id
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running[key] {
+		p.dirty[key] = true
+		return false
+	}
+	p.running[key] = true
+	go func() {
+		for {
+			p.push(repo, id)
+			p.lock.Lock()
+			if !p.dirty[key] {
+				delete(p.running, key)
+				p.lock.Unlock()
+				return
+			}
+			delete(p.dirty, key)
+			p.lock.Unlock()
+		}
+	}()
+	return true
+}
+
+// Running reports whether push to push mirror id of repo is running or waits for retry.
+func (p *Pusher) Running(repo, id string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.running[repo+"\x00"+id]
+}
+
+// push pushes until push mirror is not pending or attempts are exhausted.
+func (p *Pusher) push(repo, id string) {
+	for {
+		r, err := p.git.GetRepository(repo)
+		if err != nil {
+			logrus.Errorf("Push mirror: %v: %v", repo, err)
+			return
+		}
+		p.slots <- struct{}{}
+		m, err := r.SyncPushMirror(context.Background(), id)
+		<-p.slots
+		switch {
+		case errors.IsPushMirrorNotFound(err):
+			return
+		case m == nil:
+			logrus.Errorf("Push mirror: %v: %v", repo, err)
+			return
+		case err != nil && m.Attempts >= maxPushAttempts:
+			logrus.Errorf("Push mirror: push of %v to %v failed after %v attempts: %v", repo, m.Public().URL, m.Attempts, err)
+			return
+		case err != nil:
+			delay := pushRetryDelay << uint(m.Attempts-1)
+			if delay > maxPushDelay {
+				delay = maxPushDelay
+			}
+			logrus.Warnf("Push mirror: push of %v to %v failed, retry in %v: %v", repo, m.Public().URL, delay, err)
+			time.Sleep(delay)
+		case !m.Pending:
+			return
+		}
+	}
+}
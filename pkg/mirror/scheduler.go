@@ -0,0 +1,97 @@
+// Package mirror keeps pull mirrors in sync with their remotes and
+// pushes changes to push mirrors.
+package mirror
+
+import (
+	"encoding/json"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/jobs"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// SyncJob is the type of jobs syncing pull mirrors.
+const SyncJob = "mirror-sync"
+
+// Scheduler syncs mirrors when their interval passes and on demand.
+// Syncs run as jobs.
+type Scheduler struct {
+	git  pacakimpl.GitInterface
+	jobs *jobs.Manager
+}
+
+// NewScheduler starts checking mirrors of all repositories every checkInterval.
+func NewScheduler(git pacakimpl.GitInterface, jobManager *jobs.Manager, checkInterval time.Duration) *Scheduler {
+	s := &Scheduler{git: git, jobs: jobManager}
+	jobManager.Register(SyncJob, s.sync)
+	go func() {
+		for {
+			s.syncDue()
+			time.Sleep(checkInterval)
+		}
+	}()
+	return s
+}
+
+func (s *Scheduler) syncDue() {
+	repos, err := s.git.ListRepositories()
+	if err != nil {
+		logrus.Errorf("Mirror: failed list repositories: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, repo := range repos {
+		r, err := s.git.GetRepository(repo)
+		if err != nil {
+			continue
+		}
+		c, err := r.Mirror()
+		if err != nil {
+			logrus.Errorf("Mirror: %v: %v", repo, err)
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		status, err := r.MirrorStatus()
+		if err != nil {
+			logrus.Errorf("Mirror: %v: %v", repo, err)
+			continue
+		}
+		if c.Due(status, now) {
+			if _, err := s.Trigger(repo); err != nil {
+				logrus.Errorf("Mirror: %v: %v", repo, err)
+			}
+		}
+	}
+}
+
+// Trigger queues sync of repo. The job already queued or running is
+// returned if there is one.
+func (s *Scheduler) Trigger(repo string) (jobs.Job, error) {
+	return s.jobs.SubmitOnce(SyncJob, repo, nil)
+}
+
+// Running reports whether sync of repo is running or queued.
+func (s *Scheduler) Running(repo string) bool {
+	_, ok := s.jobs.Active(SyncJob, repo)
+	return ok
+}
+
+func (s *Scheduler) sync(ctx *jobs.Context, _ json.RawMessage) (interface{}, error) {
+	repo := ctx.Job().Repo
+	r, err := s.git.GetRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx.SetProgress(0, "fetching")
+	committer := git.Signature{Name: "pacak", Email: "pacak@kuberlab.com", When: time.Now()}
+	status, err := r.SyncMirror(ctx, committer)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Mirror: %v synced, %d refs updated", repo, status.Updated)
+	return status, nil
+}
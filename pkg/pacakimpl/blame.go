@@ -0,0 +1,99 @@
+package pacakimpl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+// Blame returns the commit which last changed each line of the file at rev.
+// Lines from..to (1-based, inclusive) are returned, zero values mean
+// the beginning and the end of the file.
+func (p *pacakRepo) Blame(rev, path string, from, to int) ([]BlameLine, error) {
+	if rev == "" {
+		rev = "master"
+	}
+	path = strings.TrimPrefix(path, "/")
+	fi, err := p.StatFileAtRev(rev, path)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return nil, errors.PathNotFound{Rev: rev, Path: path}
+	} else if err != nil {
+		return nil, errors.RevisionNotFound{Rev: rev}
+	}
+
+	cmd := git.NewCommand("blame", "--porcelain")
+	if from > 0 || to > 0 {
+		if from < 1 {
+			from = 1
+		}
+		r := strconv.Itoa(from) + ","
+		if to > 0 {
+			r += strconv.Itoa(to)
+		}
+		cmd.AddArguments("-L", r)
+	}
+	output, err := cmd.AddArguments(rev, "--", path).RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git blame %s: %v", path, err)
+	}
+	return parseBlame(output)
+}
+
+// parseBlame parses 'git blame --porcelain' output. Commit details
+// are printed only for the first line of each commit.
+func parseBlame(output string) ([]BlameLine, error) {
+	commits := map[string]*BlameLine{}
+	res := []BlameLine{}
+	var cur *BlameLine
+	var line int
+	for _, l := range strings.Split(output, "\n") {
+		if cur == nil {
+			fields := strings.Fields(l)
+			if len(fields) < 3 {
+				continue
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("Failed parse blame line '%s' - %v", l, err)
+			}
+			line = n
+			if cur = commits[fields[0]]; cur == nil {
+				cur = &BlameLine{Commit: fields[0]}
+				commits[fields[0]] = cur
+			}
+			continue
+		}
+		if strings.HasPrefix(l, "\t") {
+			b := *cur
+			b.Line = line
+			b.Content = l[1:]
+			res = append(res, b)
+			cur = nil
+			continue
+		}
+		key, value := l, ""
+		if i := strings.Index(l, " "); i >= 0 {
+			key, value = l[:i], l[i+1:]
+		}
+		switch key {
+		case "author":
+			cur.AuthorName = value
+		case "author-mail":
+			cur.AuthorEmail = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "author-time":
+			sec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Failed parse blame time '%s' - %v", value, err)
+			}
+			cur.When = time.Unix(sec, 0)
+		case "summary":
+			cur.Summary = value
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package pacakimpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+)
+
+// bundleTimeout limits creation of a single bundle.
+var bundleTimeout = time.Hour
+
+// WriteBundle writes git bundle with HEAD, all branches and tags to w.
+// If since is given, the bundle is incremental: commits reachable from
+// since (commit ids or refs) are excluded and must be present in the
+// repository the bundle is unbundled to. EmptyBundle is returned if
+// there is nothing new since given commits. Branches and tags contained
+// in the bundle are returned with commits they point to.
+func (p *pacakRepo) WriteBundle(w io.Writer, since []string) (map[string]string, error) {
+	refs, err := p.Refs()
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, errors.EmptyBundle{Repo: p.name}
+	}
+	args := []string{"bundle", "create", "", "--branches", "--tags"}
+	if p.refID("HEAD") != "" {
+		args = append(args, "HEAD")
+	}
+	for _, rev := range since {
+		id := p.refID(rev + "^{commit}")
+		if id == "" {
+			return nil, errors.RevisionNotFound{Rev: rev}
+		}
+		args = append(args, "^"+id)
+	}
+
+	f, err := ioutil.TempFile("", "pacak-bundle-*.bundle")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	args[2] = f.Name()
+	_, stderr, err := process.ExecDir(bundleTimeout, p.R.Path,
+		fmt.Sprintf("WriteBundle(git bundle create): %s", p.name), "git", args...)
+	if err != nil {
+		if strings.Contains(stderr, "empty bundle") {
+			return nil, errors.EmptyBundle{Repo: p.name}
+		}
+		return nil, fmt.Errorf("git bundle create: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	// Refs may change while the bundle is created, so read them from the bundle.
+	if refs, err = p.bundleRefs(f.Name()); err != nil {
+		return nil, err
+	}
+	f, err = os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// bundleRefs returns branches and tags of the bundle file. Annotated tags
+// are peeled to the commit they point to.
+func (p *pacakRepo) bundleRefs(file string) (map[string]string, error) {
+	stdout, stderr, err := process.ExecDir(bundleTimeout, p.R.Path,
+		fmt.Sprintf("WriteBundle(git bundle list-heads): %s", p.name),
+		"git", "bundle", "list-heads", file)
+	if err != nil {
+		return nil, fmt.Errorf("git bundle list-heads: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	refs := map[string]string{}
+	names := []string{}
+	args := []string{"rev-parse"}
+	for _, l := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			continue
+		}
+		if !strings.HasPrefix(fields[1], git.BRANCH_PREFIX) && !strings.HasPrefix(fields[1], git.TAG_PREFIX) {
+			continue
+		}
+		names = append(names, fields[1])
+		args = append(args, fields[0]+"^{}")
+	}
+	if len(names) == 0 {
+		return refs, nil
+	}
+	stdout, stderr, err = process.ExecDir(bundleTimeout, p.R.Path,
+		fmt.Sprintf("WriteBundle(git rev-parse): %s", p.name), "git", args...)
+	if err != nil {
+		return nil, fmt.Errorf("git rev-parse: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	ids := strings.Fields(stdout)
+	if len(ids) != len(names) {
+		return nil, fmt.Errorf("git rev-parse: expected %v ids, got %v", len(names), len(ids))
+	}
+	for i, name := range names {
+		refs[name] = ids[i]
+	}
+	return refs, nil
+}
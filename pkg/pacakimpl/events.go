@@ -0,0 +1,65 @@
+package pacakimpl
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	gosync "sync"
+
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+// RefUpdateListener is called after a ref of the repository has been updated.
+// Listeners are called synchronously with the update, so they must not block.
+type RefUpdateListener func(repo string, u RefUpdate)
+
+type refListeners struct {
+	lock      gosync.RWMutex
+	listeners []RefUpdateListener
+}
+
+func (l *refListeners) add(listener RefUpdateListener) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+func (l *refListeners) notify(repo string, u RefUpdate) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, listener := range l.listeners {
+		listener(repo, u)
+	}
+}
+
+// OnRefUpdate registers listener notified about every ref update
+// recorded in the reflog of any repository.
+func (g gitInterface) OnRefUpdate(listener RefUpdateListener) {
+	g.listeners.add(listener)
+}
+
+// ListRepositories returns names of all bare repositories under gitRoot.
+func (g gitInterface) ListRepositories() ([]string, error) {
+	repos := []string{}
+	err := filepath.Walk(g.gitRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == g.gitRoot && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() || p == g.gitRoot {
+			return nil
+		}
+		if util.IsExist(path.Join(p, "HEAD")) && util.IsExist(path.Join(p, "objects")) {
+			rel, err := filepath.Rel(g.gitRoot, p)
+			if err != nil {
+				return err
+			}
+			repos = append(repos, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return repos, err
+}
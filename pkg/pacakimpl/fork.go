@@ -0,0 +1,148 @@
+package pacakimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+)
+
+// forkInfo is stored in pacak metadata of both fork and its source.
+type forkInfo struct {
+	// Source is the repository objects are borrowed from.
+	Source string `json:"source,omitempty"`
+	// Forks are repositories borrowing objects of this one.
+	Forks []string `json:"forks,omitempty"`
+}
+
+func (g gitInterface) readForkInfo(repo string) (*forkInfo, error) {
+	info := &forkInfo{}
+	data, err := ioutil.ReadFile(g.path(repo, "pacak", "fork.json"))
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("Failed read fork info of %s - %v", repo, err)
+	}
+	return info, nil
+}
+
+func (g gitInterface) writeForkInfo(repo string, info *forkInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.path(repo, "pacak", "fork.json"), data)
+}
+
+// Fork creates repository dst sharing objects of src through
+// objects/info/alternates, so only new objects take disk space.
+// All branches and tags of src are copied.
+func (g gitInterface) Fork(committer git.Signature, src, dst string) error {
+	if !g.ExistsRepository(src) {
+		return errors.RepositoryNotFound{Name: src}
+	}
+	if g.ExistsRepository(dst) {
+		return errors.RepositoryAlreadyExists{Name: dst}
+	}
+	srcPath, err := filepath.Abs(g.path(src))
+	if err != nil {
+		return err
+	}
+	forkPool.CheckIn(g.path(src))
+	defer forkPool.CheckOut(g.path(src))
+
+	if err := os.MkdirAll(path.Dir(g.path(dst)), os.ModePerm); err != nil {
+		return err
+	}
+	_, stderr, err := process.ExecTimeout(cloneTimeout,
+		fmt.Sprintf("Fork(git clone --shared): %s => %s", src, dst),
+		"git", "clone", "--bare", "--shared", "--no-tags", srcPath, g.path(dst))
+	if err != nil {
+		os.RemoveAll(g.path(dst))
+		return fmt.Errorf("git clone --shared: %v - %s", err, stderr)
+	}
+	// Bare clone copies branches only.
+	if _, err := git.NewCommand("fetch", srcPath, "refs/tags/*:refs/tags/*").RunInDir(g.path(dst)); err != nil {
+		os.RemoveAll(g.path(dst))
+		return fmt.Errorf("git fetch tags: %v", err)
+	}
+	git.NewCommand("remote", "remove", "origin").RunInDir(g.path(dst))
+
+	info, err := g.readForkInfo(src)
+	if err != nil {
+		os.RemoveAll(g.path(dst))
+		return err
+	}
+	info.Forks = append(info.Forks, dst)
+	sort.Strings(info.Forks)
+	if err := g.writeForkInfo(src, info); err != nil {
+		os.RemoveAll(g.path(dst))
+		return err
+	}
+	if err := g.writeForkInfo(dst, &forkInfo{Source: src}); err != nil {
+		return err
+	}
+
+	r, err := g.GetRepository(dst)
+	if err != nil {
+		return err
+	}
+	return r.(*pacakRepo).recordAllRefs("fork", &committer)
+}
+
+// dissociateForks makes forks of repo independent of its objects before
+// it is deleted. Forks get own copy of all objects they need.
+func (g gitInterface) dissociateForks(repo string) error {
+	forkPool.CheckIn(g.path(repo))
+	defer forkPool.CheckOut(g.path(repo))
+	info, err := g.readForkInfo(repo)
+	if err != nil {
+		return err
+	}
+	for _, fork := range info.Forks {
+		if !g.ExistsRepository(fork) {
+			continue
+		}
+		if _, stderr, err := process.ExecDir(-1, g.path(fork),
+			fmt.Sprintf("Dissociate(git repack): %s", fork), "git", "repack", "-a", "-d"); err != nil {
+			return fmt.Errorf("git repack -a -d in %s: %v - %s", fork, err, stderr)
+		}
+		if err := os.Remove(g.path(fork, "objects", "info", "alternates")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		forkInfo, err := g.readForkInfo(fork)
+		if err != nil {
+			return err
+		}
+		forkInfo.Source = ""
+		if err := g.writeForkInfo(fork, forkInfo); err != nil {
+			return err
+		}
+	}
+	if info.Source != "" && g.ExistsRepository(info.Source) {
+		srcInfo, err := g.readForkInfo(info.Source)
+		if err != nil {
+			return err
+		}
+		forks := []string{}
+		for _, f := range srcInfo.Forks {
+			if f != repo {
+				forks = append(forks, f)
+			}
+		}
+		srcInfo.Forks = forks
+		return g.writeForkInfo(info.Source, srcInfo)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package pacakimpl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+const (
+	// grepMaxMatches is the number of matches after which search stops.
+	grepMaxMatches = 10000
+	// grepMaxOutput limits memory used for 'git grep' output.
+	grepMaxOutput    = 32 << 20
+	grepMaxContext   = 10
+	grepDefaultLimit = 100
+)
+
+// capWriter keeps first max bytes written to it and discards the rest.
+type capWriter struct {
+	buf    bytes.Buffer
+	max    int
+	capped bool
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	if left := w.max - w.buf.Len(); len(p) > left {
+		w.capped = true
+		w.buf.Write(p[:left])
+	} else {
+		w.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// Grep searches text files of the tree at rev using 'git grep'.
+func (p *pacakRepo) Grep(rev string, opts GrepOptions) (*GrepResult, error) {
+	if rev == "" {
+		rev = "master"
+	}
+	if opts.Query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if opts.Regex {
+		if _, err := regexp.Compile(opts.Query); err != nil {
+			return nil, errors.InvalidSearchQuery{Query: opts.Query, Reason: err.Error()}
+		}
+	}
+	for _, glob := range opts.Paths {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, errors.InvalidSearchQuery{Query: glob, Reason: err.Error()}
+		}
+	}
+	if p.refID(rev+"^{tree}") == "" {
+		return nil, errors.RevisionNotFound{Rev: rev}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = grepDefaultLimit
+	}
+	if opts.Context > grepMaxContext {
+		opts.Context = grepMaxContext
+	}
+
+	// Output line: '<rev>:<path>\0<line>\0<content>'
+	cmd := git.NewCommand("grep", "-n", "-z", "-I")
+	if opts.Regex {
+		cmd.AddArguments("-E")
+	} else {
+		cmd.AddArguments("-F")
+	}
+	if opts.IgnoreCase {
+		cmd.AddArguments("-i")
+	}
+	cmd.AddArguments("-e", opts.Query, rev, "--")
+	for _, glob := range opts.Paths {
+		cmd.AddArguments(":(glob)" + strings.TrimPrefix(glob, "/"))
+	}
+	stdout := &capWriter{max: grepMaxOutput}
+	stderr := new(bytes.Buffer)
+	if err := cmd.RunInDirPipeline(p.R.Path, stdout, stderr); err != nil {
+		// Exit status 1 without error output means nothing found.
+		if stderr.Len() > 0 || err.Error() != "exit status 1" {
+			return nil, fmt.Errorf("git grep: %v - %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	res := &GrepResult{Matches: []GrepMatch{}, Truncated: stdout.capped}
+	prefix := rev + ":"
+	lines := strings.Split(stdout.buf.String(), "\n")
+	if stdout.capped {
+		// The last line may be incomplete.
+		lines = lines[:len(lines)-1]
+	}
+	for _, l := range lines {
+		parts := strings.SplitN(l, "\x00", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if res.Total == grepMaxMatches {
+			res.Truncated = true
+			break
+		}
+		res.Total++
+		if res.Total <= opts.Offset || len(res.Matches) == opts.Limit {
+			continue
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse grep line '%s' - %v", l, err)
+		}
+		res.Matches = append(res.Matches, GrepMatch{
+			Path:    strings.TrimPrefix(parts[0], prefix),
+			Line:    n,
+			Content: parts[2],
+		})
+	}
+	if opts.Offset+len(res.Matches) < res.Total {
+		res.NextOffset = opts.Offset + len(res.Matches)
+	}
+	if opts.Context > 0 {
+		if err := p.grepContext(rev, res.Matches, opts.Context); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// grepContext fills lines around matches. Each file is read once up to
+// the last line needed, only lines around matches are kept.
+func (p *pacakRepo) grepContext(rev string, matches []GrepMatch, context int) error {
+	byPath := map[string][]*GrepMatch{}
+	paths := []string{}
+	for i := range matches {
+		m := &matches[i]
+		if _, ok := byPath[m.Path]; !ok {
+			paths = append(paths, m.Path)
+		}
+		byPath[m.Path] = append(byPath[m.Path], m)
+	}
+	for _, file := range paths {
+		wanted := map[int]string{}
+		last := 0
+		for _, m := range byPath[file] {
+			for n := m.Line - context; n <= m.Line+context; n++ {
+				wanted[n] = ""
+			}
+			if m.Line+context > last {
+				last = m.Line + context
+			}
+		}
+		r, err := p.GetFileAtRev(rev, file)
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReader(r)
+		total := 0
+		for total < last {
+			l, err := br.ReadString('\n')
+			if l == "" && err == io.EOF {
+				break
+			}
+			if err != nil && err != io.EOF {
+				return err
+			}
+			total++
+			if _, ok := wanted[total]; ok {
+				wanted[total] = strings.TrimSuffix(l, "\n")
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+		for _, m := range byPath[file] {
+			for n := m.Line - context; n < m.Line; n++ {
+				if n >= 1 && n <= total {
+					m.Before = append(m.Before, wanted[n])
+				}
+			}
+			for n := m.Line + 1; n <= m.Line+context && n <= total; n++ {
+				m.After = append(m.After, wanted[n])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package pacakimpl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+// FileHistory returns commits reachable from rev which changed the file
+// at path, newest first. Renames are followed. Zero limit means no limit.
+func (p *pacakRepo) FileHistory(rev, path string, limit int) ([]FileVersion, error) {
+	if rev == "" {
+		rev = "master"
+	}
+	path = strings.TrimPrefix(path, "/")
+	fi, err := p.StatFileAtRev(rev, path)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return nil, errors.PathNotFound{Rev: rev, Path: path}
+	} else if err != nil {
+		return nil, errors.RevisionNotFound{Rev: rev}
+	}
+
+	cmd := git.NewCommand(
+		"-c", "core.quotepath=off",
+		"log", "--follow", "--raw", "--no-abbrev",
+		"--format=%x1e%H%x1f%an%x1f%ae%x1f%at%x1f%s",
+	)
+	if limit > 0 {
+		cmd.AddArguments("-n", strconv.Itoa(limit))
+	}
+	output, err := cmd.AddArguments(rev, "--", path).RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %v", path, err)
+	}
+
+	res := []FileVersion{}
+	for _, record := range strings.Split(output, "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		header := strings.Split(lines[0], "\x1f")
+		if len(header) < 5 {
+			continue
+		}
+		sec, err := strconv.ParseInt(header[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse commit time '%s' - %v", header[3], err)
+		}
+		v := FileVersion{
+			Commit:      header[0],
+			AuthorName:  header[1],
+			AuthorEmail: header[2],
+			When:        time.Unix(sec, 0),
+			Summary:     header[4],
+		}
+		// Raw line: ':<old mode> <new mode> <old blob> <new blob> <status>\t<path>[\t<new path>]'
+		for _, l := range lines[1:] {
+			if !strings.HasPrefix(l, ":") {
+				continue
+			}
+			parts := strings.Split(l, "\t")
+			fields := strings.Fields(parts[0])
+			if len(fields) < 5 || len(parts) < 2 {
+				continue
+			}
+			v.Status = fields[4][:1]
+			v.Path = parts[len(parts)-1]
+			if fields[3] != emptyID {
+				v.Blob = fields[3]
+			}
+		}
+		res = append(res, v)
+	}
+	if err := p.fillBlobSizes(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fillBlobSizes sets sizes of version blobs using single 'git cat-file' call.
+func (p *pacakRepo) fillBlobSizes(versions []FileVersion) error {
+	ids := []string{}
+	for _, v := range versions {
+		if v.Blob != "" {
+			ids = append(ids, v.Blob)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	cmd := exec.Command("git", "cat-file", "--batch-check")
+	cmd.Dir = p.R.Path
+	cmd.Stdin = strings.NewReader(strings.Join(ids, "\n") + "\n")
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git cat-file --batch-check: %v - %s", err, stderr.String())
+	}
+	// Output line: '<id> <type> <size>'
+	sizes := map[string]int64{}
+	for _, l := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 3 {
+			continue
+		}
+		if size, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			sizes[fields[0]] = size
+		}
+	}
+	for i := range versions {
+		versions[i].Size = sizes[versions[i].Blob]
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package pacakimpl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+	"github.com/kuberlab/pacak/pkg/sync"
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+// importTimeout limits fetch of a single imported repository.
+var importTimeout = time.Hour
+
+var importPool = sync.NewExclusivePool()
+
+// ImportRepository creates repository from src. Src is path of a git
+// repository (bare or not), a git bundle file or a plain directory tree.
+// All branches and tags with full history are imported from git sources,
+// a directory tree becomes the initial commit on master. Git commands are
+// killed when ctx is done.
+func (g gitInterface) ImportRepository(ctx context.Context, committer git.Signature, repo, src string) error {
+	importPool.CheckIn(g.path(repo))
+	defer importPool.CheckOut(g.path(repo))
+	if g.ExistsRepository(repo) {
+		return errors.RepositoryAlreadyExists{Name: repo}
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("Import: %v", err)
+	}
+	src, err = filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !info.IsDir():
+		if _, stderr, err := process.ExecDirContext(ctx, importTimeout, "",
+			fmt.Sprintf("Import(git bundle verify): %s", src),
+			"git", "bundle", "verify", src); err != nil {
+			return fmt.Errorf("Import: invalid git bundle: %v - %s", err, strings.TrimSpace(stderr))
+		}
+	case util.IsExist(path.Join(src, "HEAD")) && util.IsExist(path.Join(src, "objects")):
+	case util.IsExist(path.Join(src, ".git")):
+	default:
+		if err := g.importDir(ctx, committer, repo, src); err != nil {
+			os.RemoveAll(g.path(repo))
+			return err
+		}
+		r, err := g.GetRepository(repo)
+		if err != nil {
+			return err
+		}
+		return r.(*pacakRepo).recordAllRefs("import", &committer)
+	}
+
+	if err := g.fetchImport(ctx, repo, src); err != nil {
+		os.RemoveAll(g.path(repo))
+		return err
+	}
+	r, err := g.GetRepository(repo)
+	if err != nil {
+		return err
+	}
+	return r.(*pacakRepo).recordAllRefs("import", &committer)
+}
+
+// fetchImport creates bare repository with branches and tags of src.
+func (g gitInterface) fetchImport(ctx context.Context, repo, src string) error {
+	repoPath := g.path(repo)
+	if err := git.InitRepository(repoPath, true); err != nil {
+		return fmt.Errorf("InitRepository: %v", err)
+	}
+	_, stderr, err := process.ExecDirContext(ctx, importTimeout, repoPath,
+		fmt.Sprintf("Import(git fetch): %s => %s", src, repo),
+		"git", "fetch", "--no-tags", src, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return fmt.Errorf("git fetch: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	branches, err := git.NewCommand("for-each-ref", "--format=%(refname)", git.BRANCH_PREFIX).RunInDir(repoPath)
+	if err != nil {
+		return fmt.Errorf("git for-each-ref: %v", err)
+	}
+	refs := strings.Fields(branches)
+	if len(refs) == 0 {
+		return fmt.Errorf("Import: %s has no branches", src)
+	}
+	sort.Strings(refs)
+	head := importHead(repoPath, src, refs)
+	if _, err := git.NewCommand("symbolic-ref", "HEAD", head).RunInDir(repoPath); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v", err)
+	}
+	return nil
+}
+
+// importDir creates bare repository with the initial commit of the src
+// tree on master. Files are added by git straight from src, so the tree
+// is never loaded into memory.
+func (g gitInterface) importDir(ctx context.Context, committer git.Signature, repo, src string) error {
+	repoPath := g.path(repo)
+	if err := git.InitRepository(repoPath, true); err != nil {
+		return fmt.Errorf("InitRepository: %v", err)
+	}
+	if _, err := git.NewCommand("symbolic-ref", "HEAD", git.BRANCH_PREFIX+"master").RunInDir(repoPath); err != nil {
+		return fmt.Errorf("git symbolic-ref: %v", err)
+	}
+	gitArgs := []string{
+		"--git-dir=" + repoPath, "--work-tree=" + src,
+		"-c", "user.name=" + committer.Name, "-c", "user.email=" + committer.Email,
+	}
+	// Index and message are used only to create the commit.
+	defer os.Remove(path.Join(repoPath, "index"))
+	defer os.Remove(path.Join(repoPath, "COMMIT_EDITMSG"))
+	_, stderr, err := process.ExecDirContext(ctx, importTimeout, src,
+		fmt.Sprintf("Import(git add): %s => %s", src, repo),
+		"git", append(gitArgs, "add", "--all", "--force", ".")...)
+	if err != nil {
+		return fmt.Errorf("git add: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	_, stderr, err = process.ExecDirContext(ctx, importTimeout, src,
+		fmt.Sprintf("Import(git commit): %s => %s", src, repo),
+		"git", append(gitArgs, "commit", "--allow-empty", "--no-verify", "-m", "Initial commit")...)
+	if err != nil {
+		return fmt.Errorf("git commit: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// importHead returns default branch of src. Bundles have no symbolic HEAD,
+// so the branch HEAD points to is chosen. Falls back to master or the
+// first of sorted refs.
+func importHead(repoPath, src string, refs []string) string {
+	stdout, _ := git.NewCommand("ls-remote", "--symref", src, "HEAD").RunInDir(repoPath)
+	headID := ""
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "ref:" {
+			return fields[1]
+		}
+		if len(fields) == 2 && fields[1] == "HEAD" {
+			headID = fields[0]
+		}
+	}
+	if headID != "" {
+		for _, ref := range refs {
+			if id, err := git.NewCommand("rev-parse", ref).RunInDir(repoPath); err == nil && strings.TrimSpace(id) == headID {
+				return ref
+			}
+		}
+	}
+	if i := sort.SearchStrings(refs, git.BRANCH_PREFIX+"master"); i < len(refs) && refs[i] == git.BRANCH_PREFIX+"master" {
+		return refs[i]
+	}
+	return refs[0]
+}
+
+// recordAllRefs records creation of all branches and tags of repository.
+func (p *pacakRepo) recordAllRefs(action string, committer *git.Signature) error {
+	refs, err := p.Refs()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		p.recordRefUpdate(ref, "", p.refID(ref), action, committer)
+	}
+	return nil
+}
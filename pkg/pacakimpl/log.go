@@ -0,0 +1,82 @@
+package pacakimpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+)
+
+// Refs returns commits of all branches and tags keyed by full ref name.
+// Annotated tags are peeled to the commit they point to.
+func (p *pacakRepo) Refs() (map[string]string, error) {
+	output, err := git.NewCommand(
+		"for-each-ref", "--format=%(objectname) %(*objectname) %(refname)",
+		"refs/heads", "refs/tags",
+	).RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v", err)
+	}
+	refs := map[string]string{}
+	for _, l := range strings.Split(output, "\n") {
+		fields := strings.Fields(l)
+		switch len(fields) {
+		case 2:
+			refs[fields[1]] = fields[0]
+		case 3:
+			// Annotated tag, use the object it points to.
+			refs[fields[2]] = fields[1]
+		}
+	}
+	return refs, nil
+}
+
+// LogChanges returns commits reachable from rev but not from any of
+// exclude revisions, with paths changed by each commit, newest first.
+func (p *pacakRepo) LogChanges(rev string, exclude ...string) ([]CommitChanges, error) {
+	cmd := git.NewCommand(
+		"-c", "core.quotepath=off",
+		"log", "--name-only",
+		"--format=%x1e%H%x1f%P%x1f%an%x1f%ae%x1f%at%x1f%B%x1f",
+		rev,
+	)
+	for _, e := range exclude {
+		cmd.AddArguments("^" + e)
+	}
+	output, err := cmd.AddArguments("--").RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %v", rev, err)
+	}
+
+	res := []CommitChanges{}
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.SplitN(record, "\x1f", 7)
+		if len(fields) < 7 {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse commit time '%s' - %v", fields[4], err)
+		}
+		c := CommitChanges{
+			Commit: Commit{
+				ID:          fields[0],
+				Parents:     strings.Fields(fields[1]),
+				AuthorName:  fields[2],
+				AuthorEmail: fields[3],
+				When:        time.Unix(sec, 0),
+				Message:     strings.TrimSpace(fields[5]),
+			},
+			Paths: []string{},
+		}
+		for _, l := range strings.Split(fields[6], "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				c.Paths = append(c.Paths, l)
+			}
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
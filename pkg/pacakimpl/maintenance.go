@@ -0,0 +1,241 @@
+package pacakimpl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/process"
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+// Maintenance tasks in the order they are run.
+const (
+	TaskGC          = "gc"
+	TaskRepack      = "repack"
+	TaskCommitGraph = "commit-graph"
+	TaskFsck        = "fsck"
+)
+
+var maintenanceTasks = []string{TaskGC, TaskRepack, TaskCommitGraph, TaskFsck}
+
+// maintenanceTimeout limits a single maintenance task.
+var maintenanceTimeout = 6 * time.Hour
+
+// maxProblems is the number of fsck problems kept in status.
+const maxProblems = 100
+
+// ObjectStats is the output of 'git count-objects -v'.
+type ObjectStats struct {
+	// Loose is the number of loose objects, Size is their size in KiB.
+	Loose    int `json:"loose"`
+	Size     int `json:"size"`
+	InPack   int `json:"in_pack"`
+	Packs    int `json:"packs"`
+	SizePack int `json:"size_pack"`
+	Garbage  int `json:"garbage"`
+}
+
+// TaskResult is the result of a maintenance task.
+type TaskResult struct {
+	Task     string    `json:"task"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// MaintenanceStatus is the result of the last maintenance of repository.
+type MaintenanceStatus struct {
+	LastRun  *time.Time   `json:"last_run,omitempty"`
+	LastFsck *time.Time   `json:"last_fsck,omitempty"`
+	Tasks    []TaskResult `json:"tasks,omitempty"`
+	Before   *ObjectStats `json:"before,omitempty"`
+	After    *ObjectStats `json:"after,omitempty"`
+	// Corrupt is set if the last fsck found problems.
+	Corrupt  bool     `json:"corrupt"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// ObjectStats returns object counts of the bare repository.
+func (p *pacakRepo) ObjectStats() (*ObjectStats, error) {
+	stdout, stderr, err := process.ExecDir(-1, p.R.Path,
+		fmt.Sprintf("ObjectStats(git count-objects): %s", p.name), "git", "count-objects", "-v")
+	if err != nil {
+		return nil, fmt.Errorf("git count-objects: %v - %s", err, stderr)
+	}
+	stats := &ObjectStats{}
+	fields := map[string]*int{
+		"count":     &stats.Loose,
+		"size":      &stats.Size,
+		"in-pack":   &stats.InPack,
+		"packs":     &stats.Packs,
+		"size-pack": &stats.SizePack,
+		"garbage":   &stats.Garbage,
+	}
+	for _, l := range strings.Split(stdout, "\n") {
+		kv := strings.SplitN(l, ": ", 2)
+		if len(kv) != 2 || fields[kv[0]] == nil {
+			continue
+		}
+		*fields[kv[0]], _ = strconv.Atoi(strings.TrimSpace(kv[1]))
+	}
+	return stats, nil
+}
+
+// MaintenanceStatus returns the result of the last maintenance.
+func (p *pacakRepo) MaintenanceStatus() (*MaintenanceStatus, error) {
+	status := &MaintenanceStatus{}
+	data, err := ioutil.ReadFile(p.metaPath("maintenance.json"))
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("Failed read maintenance status - %v", err)
+	}
+	return status, nil
+}
+
+// Maintain runs maintenance tasks and saves their results:
+//
+//	gc           packs loose objects and refs, prunes old unreachable objects
+//	repack       packs all objects into a single pack
+//	commit-graph writes commit-graph file speeding up history walks
+//	fsck         checks connectivity and validity of objects
+//
+// Writes to the repository wait while gc and repack run. Unreachable
+// objects of repositories having forks are never pruned, forks may use
+// them. Failed tasks do not stop others; the first error is returned.
+func (p *pacakRepo) Maintain(ctx context.Context, tasks []string) (*MaintenanceStatus, error) {
+	run := map[string]bool{}
+	for _, t := range tasks {
+		if !IsMaintenanceTask(t) {
+			return nil, fmt.Errorf("Unknown maintenance task '%s'", t)
+		}
+		run[t] = true
+	}
+	status, err := p.MaintenanceStatus()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	status.LastRun = &now
+	status.Tasks = []TaskResult{}
+	if status.Before, err = p.ObjectStats(); err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	for _, task := range maintenanceTasks {
+		if !run[task] {
+			continue
+		}
+		if ctx.Err() != nil {
+			firstErr = ctx.Err()
+			break
+		}
+		res := TaskResult{Task: task, Started: time.Now()}
+		err := p.runMaintenanceTask(ctx, task, status)
+		res.Duration = time.Since(res.Started).Seconds()
+		if err != nil {
+			res.Error = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", task, err)
+			}
+		}
+		status.Tasks = append(status.Tasks, res)
+	}
+	if after, err := p.ObjectStats(); err == nil {
+		status.After = after
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(p.metaPath("maintenance.json"), data); err != nil {
+		return nil, err
+	}
+	return status, firstErr
+}
+
+// IsMaintenanceTask reports whether task is a known maintenance task.
+func IsMaintenanceTask(task string) bool {
+	for _, t := range maintenanceTasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pacakRepo) runMaintenanceTask(ctx context.Context, task string, status *MaintenanceStatus) error {
+	var args []string
+	switch task {
+	case TaskGC:
+		args = []string{"gc", "--quiet"}
+		if p.hasForks() {
+			args = append(args, "--prune=never")
+		}
+	case TaskRepack:
+		args = []string{"repack", "-a", "-d", "-l", "-q"}
+		if p.hasForks() {
+			args = append(args, "--keep-unreachable")
+		}
+	case TaskCommitGraph:
+		args = []string{"commit-graph", "write", "--reachable"}
+	case TaskFsck:
+		return p.fsck(ctx, status)
+	}
+	defer p.lockLocalCopy()()
+	_, stderr, err := process.ExecDirContext(ctx, maintenanceTimeout, p.R.Path,
+		fmt.Sprintf("Maintain(git %s): %s", task, p.name), "git", args...)
+	if err != nil {
+		return fmt.Errorf("git %s: %v - %s", task, err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// fsck checks the repository recording problems found in status.
+// It runs without locks as it does not change the repository.
+func (p *pacakRepo) fsck(ctx context.Context, status *MaintenanceStatus) error {
+	stdout, stderr, err := process.ExecDirContext(ctx, maintenanceTimeout, p.R.Path,
+		fmt.Sprintf("Maintain(git fsck): %s", p.name), "git", "fsck", "--no-progress", "--no-dangling")
+	if ctx.Err() != nil || err == process.ErrExecTimeout {
+		return err
+	}
+	now := time.Now()
+	status.LastFsck = &now
+	status.Problems = nil
+	for _, l := range strings.Split(stdout+"\n"+stderr, "\n") {
+		if l = strings.TrimSpace(l); l != "" && len(status.Problems) < maxProblems {
+			status.Problems = append(status.Problems, l)
+		}
+	}
+	status.Corrupt = err != nil
+	if status.Corrupt {
+		return fmt.Errorf("repository is corrupt: %v", err)
+	}
+	return nil
+}
+
+// hasForks reports whether other repositories borrow objects of this one.
+func (p *pacakRepo) hasForks() bool {
+	if !util.IsExist(p.metaPath("fork.json")) {
+		return false
+	}
+	info := &forkInfo{}
+	data, err := ioutil.ReadFile(p.metaPath("fork.json"))
+	if err != nil || json.Unmarshal(data, info) != nil {
+		// Be safe, pruned objects can not be restored.
+		return true
+	}
+	return len(info.Forks) > 0
+}
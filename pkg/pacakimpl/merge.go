@@ -0,0 +1,171 @@
+package pacakimpl
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+type MergeStrategy string
+
+const (
+	// MergeFastForward moves target to source and fails if target has diverged.
+	MergeFastForward MergeStrategy = "ff-only"
+	// MergeCommit always records a merge commit.
+	MergeCommit MergeStrategy = "merge"
+	// MergeSquash records all changes of source as a single commit on target.
+	MergeSquash MergeStrategy = "squash"
+)
+
+var conflictTypes = map[string]string{
+	"UU": "both-modified",
+	"AA": "both-added",
+	"DD": "both-deleted",
+	"AU": "added-by-us",
+	"UA": "added-by-them",
+	"DU": "deleted-by-us",
+	"UD": "deleted-by-them",
+}
+
+// signatureEnvs returns environment which makes git use given signature
+// as both author and committer.
+func signatureEnvs(sig git.Signature) []string {
+	return []string{
+		"GIT_AUTHOR_NAME=" + sig.Name,
+		"GIT_AUTHOR_EMAIL=" + sig.Email,
+		"GIT_COMMITTER_NAME=" + sig.Name,
+		"GIT_COMMITTER_EMAIL=" + sig.Email,
+	}
+}
+
+// Merge merges source branch (or any revision) into target branch using given strategy.
+// It returns errors.MergeConflict listing conflicting paths if changes can not be merged
+// and errors.AlreadyUpToDate if squash of source changes nothing in target.
+func (p *pacakRepo) Merge(source, target string, strategy MergeStrategy, committer git.Signature, message string) (string, error) {
+	return p.merge(source, target, strategy, committer, message, "merge")
+}
+
+// merge merges source into target and records the update with given action.
+func (p *pacakRepo) merge(source, target string, strategy MergeStrategy, committer git.Signature, message, action string) (string, error) {
+	defer p.lockLocalCopy()()
+	if target == "" {
+		target = "master"
+	}
+	if strategy == "" {
+		strategy = MergeCommit
+	}
+	if strategy != MergeFastForward && strategy != MergeCommit && strategy != MergeSquash {
+		return "", fmt.Errorf("unknown merge strategy '%s'", strategy)
+	}
+	if err := p.prepareLocalBranch(target); err != nil {
+		return "", err
+	}
+	sourceRev, err := p.localRev(source)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = fmt.Sprintf("Merge '%s' into %s", source, target)
+	}
+
+	cmd := git.NewCommand("merge").AddEnvs(signatureEnvs(committer)...)
+	switch strategy {
+	case MergeFastForward:
+		cmd.AddArguments("--ff-only")
+	case MergeCommit:
+		cmd.AddArguments("--no-ff", "-m", message)
+	case MergeSquash:
+		cmd.AddArguments("--squash")
+	}
+	if _, err := cmd.AddArguments(sourceRev).RunInDir(p.LocalPath); err != nil {
+		conflicts, cerr := p.abortConflicts("merge")
+		if cerr != nil {
+			return "", cerr
+		}
+		if len(conflicts) > 0 {
+			return "", errors.MergeConflict{Source: source, Target: target, Conflicts: conflicts}
+		}
+		if strategy == MergeFastForward && !p.isAncestor("HEAD", sourceRev) {
+			return "", errors.NotFastForward{Source: source, Target: target}
+		}
+		return "", fmt.Errorf("git merge %s: %v", source, err)
+	}
+	if strategy == MergeSquash {
+		// Squash of changes already in target leaves nothing to commit.
+		if _, err := git.NewCommand("diff", "--cached", "--quiet").RunInDir(p.LocalPath); err == nil {
+			os.Remove(path.Join(p.LocalPath, ".git", "SQUASH_MSG"))
+			return "", errors.AlreadyUpToDate{Source: source, Target: target}
+		}
+		if err := git.CommitChanges(p.LocalPath, git.CommitChangesOptions{
+			Committer: &committer,
+			Message:   message,
+		}); err != nil {
+			return "", fmt.Errorf("CommitChanges: %v", err)
+		}
+	}
+	return p.pushLocal(target, action, committer)
+}
+
+// localRev returns revision of the local copy corresponding to given branch or commit.
+func (p *pacakRepo) localRev(rev string) (string, error) {
+	local := rev
+	if git.IsBranchExist(p.R.Path, rev) {
+		local = "origin/" + rev
+	}
+	if _, err := git.NewCommand("rev-parse", "--verify", local+"^{commit}").RunInDir(p.LocalPath); err != nil {
+		return "", errors.RevisionNotFound{Rev: rev}
+	}
+	return local, nil
+}
+
+// isAncestor reports whether commit is an ancestor of rev in the local copy.
+func (p *pacakRepo) isAncestor(commit, rev string) bool {
+	_, err := git.NewCommand("merge-base", "--is-ancestor", commit, rev).RunInDir(p.LocalPath)
+	return err == nil
+}
+
+// pushLocal pushes branch of the local copy, records the update
+// in the reflog and returns new head.
+func (p *pacakRepo) pushLocal(branch, action string, committer git.Signature) (string, error) {
+	old := p.refID(git.BRANCH_PREFIX + branch)
+	if err := p.beforePush(branch, action); err != nil {
+		return "", err
+	}
+	if err := git.Push(p.LocalPath, "origin", branch); err != nil {
+		return "", fmt.Errorf("git push origin %s: %v", branch, err)
+	}
+	commit, err := p.R.GetBranchCommit(branch)
+	if err != nil {
+		return "", fmt.Errorf("Read last commit error %v", err)
+	}
+	p.recordRefUpdate(git.BRANCH_PREFIX+branch, old, commit.ID.String(), action, &committer)
+	return commit.ID.String(), nil
+}
+
+// abortConflicts lists unmerged paths of the local copy, aborts given
+// operation (merge, revert or cherry-pick) and resets local copy to HEAD.
+func (p *pacakRepo) abortConflicts(op string) ([]errors.Conflict, error) {
+	output, err := git.NewCommand("status", "--porcelain", "-z").RunInDir(p.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("git status: %v", err)
+	}
+	conflicts := []errors.Conflict{}
+	for _, entry := range strings.Split(output, "\x00") {
+		if len(entry) < 4 || entry[2] != ' ' {
+			continue
+		}
+		if t, ok := conflictTypes[entry[:2]]; ok {
+			conflicts = append(conflicts, errors.Conflict{Path: entry[3:], Type: t})
+		}
+	}
+	// Operation may be not in progress (e.g. squash merge), so ignore the error.
+	git.NewCommand(op, "--abort").RunInDir(p.LocalPath)
+	if err := git.ResetHEAD(p.LocalPath, true, "HEAD"); err != nil {
+		return nil, fmt.Errorf("git reset --hard HEAD: %v", err)
+	}
+	return conflicts, nil
+}
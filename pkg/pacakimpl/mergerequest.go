@@ -0,0 +1,430 @@
+package pacakimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/sync"
+)
+
+// mergeRequestAction is the reflog action of merges made by merge requests.
+const mergeRequestAction = "merge-request"
+
+const (
+	MergeRequestOpen   = "open"
+	MergeRequestMerged = "merged"
+	MergeRequestClosed = "closed"
+)
+
+var mergeRequestPool = sync.NewExclusivePool()
+
+// MergeRequest is a request to merge source branch into target branch.
+type MergeRequest struct {
+	ID          int64                  `json:"id"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Source      string                 `json:"source"`
+	Target      string                 `json:"target"`
+	Author      string                 `json:"author"`
+	State       string                 `json:"state"`
+	Comments    []MergeRequestComment  `json:"comments"`
+	Approvals   []MergeRequestApproval `json:"approvals"`
+	// SourceCommit and TargetCommit are heads of branches at the time of merge.
+	SourceCommit string    `json:"sourceCommit,omitempty"`
+	TargetCommit string    `json:"targetCommit,omitempty"`
+	MergeCommit  string    `json:"mergeCommit,omitempty"`
+	ClosedBy     string    `json:"closedBy,omitempty"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+}
+
+type MergeRequestComment struct {
+	ID     int       `json:"id"`
+	Author string    `json:"author"`
+	Body   string    `json:"body"`
+	When   time.Time `json:"when"`
+}
+
+// MergeRequestApproval approves source branch at given commit. It does
+// not count once the source branch moves.
+type MergeRequestApproval struct {
+	Author string    `json:"author"`
+	Email  string    `json:"email"`
+	Commit string    `json:"commit"`
+	When   time.Time `json:"when"`
+}
+
+// MergeRequestStatus describes whether merge request can be merged.
+type MergeRequestStatus struct {
+	State        string `json:"state"`
+	SourceCommit string `json:"sourceCommit"`
+	TargetCommit string `json:"targetCommit"`
+	MergeBase    string `json:"mergeBase"`
+	// Ahead is the number of source commits not merged to target yet,
+	// Behind is the number of target commits missing in source.
+	Ahead             int               `json:"ahead"`
+	Behind            int               `json:"behind"`
+	Mergeable         bool              `json:"mergeable"`
+	Conflicts         []errors.Conflict `json:"conflicts,omitempty"`
+	Approvals         int               `json:"approvals"`
+	RequiredApprovals int               `json:"requiredApprovals"`
+}
+
+func signatureString(sig git.Signature) string {
+	return fmt.Sprintf("%s <%s>", sig.Name, sig.Email)
+}
+
+// signatureEmail returns email of the signature formatted by signatureString.
+func signatureEmail(s string) string {
+	start := strings.LastIndex(s, "<")
+	if start < 0 || !strings.HasSuffix(s, ">") {
+		return s
+	}
+	return s[start+1 : len(s)-1]
+}
+
+func (p *pacakRepo) mergeRequestPath(id int64) string {
+	return p.metaPath("merge-requests", strconv.FormatInt(id, 10)+".json")
+}
+
+func (p *pacakRepo) readMergeRequest(id int64) (*MergeRequest, error) {
+	data, err := ioutil.ReadFile(p.mergeRequestPath(id))
+	if os.IsNotExist(err) {
+		return nil, errors.MergeRequestNotFound{ID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	mr := &MergeRequest{}
+	if err := json.Unmarshal(data, mr); err != nil {
+		return nil, fmt.Errorf("Failed read merge request %d - %v", id, err)
+	}
+	return mr, nil
+}
+
+func (p *pacakRepo) writeMergeRequest(mr *MergeRequest) error {
+	mr.Updated = time.Now()
+	data, err := json.Marshal(mr)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.mergeRequestPath(mr.ID), data)
+}
+
+// mergeRequestIDs returns IDs of all merge requests in ascending order.
+func (p *pacakRepo) mergeRequestIDs() ([]int64, error) {
+	files, err := ioutil.ReadDir(p.metaPath("merge-requests"))
+	if os.IsNotExist(err) {
+		return []int64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for _, f := range files {
+		if id, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64); err == nil && strings.HasSuffix(f.Name(), ".json") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// updateMergeRequest reads merge request, changes it with f and writes it back.
+func (p *pacakRepo) updateMergeRequest(id int64, f func(mr *MergeRequest) error) (*MergeRequest, error) {
+	mergeRequestPool.CheckIn(p.R.Path)
+	defer mergeRequestPool.CheckOut(p.R.Path)
+	mr, err := p.readMergeRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := f(mr); err != nil {
+		return nil, err
+	}
+	if err := p.writeMergeRequest(mr); err != nil {
+		return nil, err
+	}
+	return mr, nil
+}
+
+// CreateMergeRequest opens a request to merge source branch into target branch.
+func (p *pacakRepo) CreateMergeRequest(author git.Signature, source, target, title, description string) (*MergeRequest, error) {
+	if target == "" {
+		target = "master"
+	}
+	for _, b := range []string{source, target} {
+		if !git.IsBranchExist(p.R.Path, b) {
+			return nil, errors.BranchNotFound{Name: b}
+		}
+	}
+	if source == target {
+		return nil, fmt.Errorf("source and target branches are the same")
+	}
+	if title == "" {
+		title = fmt.Sprintf("Merge '%s' into %s", source, target)
+	}
+	mergeRequestPool.CheckIn(p.R.Path)
+	defer mergeRequestPool.CheckOut(p.R.Path)
+	ids, err := p.mergeRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+	mr := &MergeRequest{
+		ID:          1,
+		Title:       title,
+		Description: description,
+		Source:      source,
+		Target:      target,
+		Author:      signatureString(author),
+		State:       MergeRequestOpen,
+		Comments:    []MergeRequestComment{},
+		Approvals:   []MergeRequestApproval{},
+		Created:     time.Now(),
+	}
+	if len(ids) > 0 {
+		mr.ID = ids[len(ids)-1] + 1
+	}
+	if err := p.writeMergeRequest(mr); err != nil {
+		return nil, err
+	}
+	return mr, nil
+}
+
+// MergeRequests returns merge requests in given state (all if empty), newest first.
+func (p *pacakRepo) MergeRequests(state string) ([]MergeRequest, error) {
+	mergeRequestPool.CheckIn(p.R.Path)
+	defer mergeRequestPool.CheckOut(p.R.Path)
+	ids, err := p.mergeRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+	res := []MergeRequest{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		mr, err := p.readMergeRequest(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		if state == "" || mr.State == state {
+			res = append(res, *mr)
+		}
+	}
+	return res, nil
+}
+
+func (p *pacakRepo) GetMergeRequest(id int64) (*MergeRequest, error) {
+	mergeRequestPool.CheckIn(p.R.Path)
+	defer mergeRequestPool.CheckOut(p.R.Path)
+	return p.readMergeRequest(id)
+}
+
+func (p *pacakRepo) CommentMergeRequest(author git.Signature, id int64, body string) (*MergeRequest, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("comment is empty")
+	}
+	return p.updateMergeRequest(id, func(mr *MergeRequest) error {
+		c := MergeRequestComment{
+			ID:     1,
+			Author: signatureString(author),
+			Body:   body,
+			When:   time.Now(),
+		}
+		if n := len(mr.Comments); n > 0 {
+			c.ID = mr.Comments[n-1].ID + 1
+		}
+		mr.Comments = append(mr.Comments, c)
+		return nil
+	})
+}
+
+// ApproveMergeRequest approves current head of the source branch.
+// Author of the merge request can not approve it.
+func (p *pacakRepo) ApproveMergeRequest(author git.Signature, id int64) (*MergeRequest, error) {
+	return p.updateMergeRequest(id, func(mr *MergeRequest) error {
+		if mr.State != MergeRequestOpen {
+			return errors.MergeRequestNotOpen{ID: mr.ID, State: mr.State}
+		}
+		// Approvals are counted per email, so is the author.
+		if signatureEmail(mr.Author) == author.Email {
+			return errors.SelfApproval{ID: mr.ID}
+		}
+		head := p.refID(git.BRANCH_PREFIX + mr.Source)
+		if head == "" {
+			return errors.BranchNotFound{Name: mr.Source}
+		}
+		approvals := []MergeRequestApproval{}
+		for _, a := range mr.Approvals {
+			if a.Email != author.Email {
+				approvals = append(approvals, a)
+			}
+		}
+		mr.Approvals = append(approvals, MergeRequestApproval{
+			Author: signatureString(author),
+			Email:  author.Email,
+			Commit: head,
+			When:   time.Now(),
+		})
+		return nil
+	})
+}
+
+func (p *pacakRepo) CloseMergeRequest(author git.Signature, id int64) (*MergeRequest, error) {
+	return p.updateMergeRequest(id, func(mr *MergeRequest) error {
+		if mr.State != MergeRequestOpen {
+			return errors.MergeRequestNotOpen{ID: mr.ID, State: mr.State}
+		}
+		mr.State = MergeRequestClosed
+		mr.ClosedBy = signatureString(author)
+		return nil
+	})
+}
+
+// validApprovals returns number of approvals of the source head.
+func validApprovals(mr *MergeRequest, head string) int {
+	n := 0
+	for _, a := range mr.Approvals {
+		if a.Commit == head {
+			n++
+		}
+	}
+	return n
+}
+
+// MergeRequestStatus computes divergence of branches, conflicts and approvals
+// of the merge request. Conflicts are checked by a trial merge in the local copy.
+func (p *pacakRepo) MergeRequestStatus(id int64) (*MergeRequestStatus, error) {
+	mr, err := p.GetMergeRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	status := &MergeRequestStatus{
+		State:        mr.State,
+		SourceCommit: mr.SourceCommit,
+		TargetCommit: mr.TargetCommit,
+	}
+	if mr.State == MergeRequestOpen {
+		status.SourceCommit = p.refID(git.BRANCH_PREFIX + mr.Source)
+		status.TargetCommit = p.refID(git.BRANCH_PREFIX + mr.Target)
+	}
+	if status.SourceCommit == "" {
+		return nil, errors.BranchNotFound{Name: mr.Source}
+	}
+	if status.TargetCommit == "" {
+		return nil, errors.BranchNotFound{Name: mr.Target}
+	}
+	output, err := git.NewCommand("merge-base", status.TargetCommit, status.SourceCommit).RunInDir(p.R.Path)
+	if err == nil {
+		status.MergeBase = strings.TrimSpace(output)
+	}
+	output, err = git.NewCommand("rev-list", "--left-right", "--count", status.TargetCommit+"..."+status.SourceCommit).RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list: %v", err)
+	}
+	if fields := strings.Fields(output); len(fields) == 2 {
+		status.Behind, _ = strconv.Atoi(fields[0])
+		status.Ahead, _ = strconv.Atoi(fields[1])
+	}
+	status.Approvals = validApprovals(mr, status.SourceCommit)
+	rules, err := p.Protection()
+	if err != nil {
+		return nil, err
+	}
+	status.RequiredApprovals = rules.requiredApprovals(mr.Target)
+	if mr.State != MergeRequestOpen {
+		return status, nil
+	}
+	status.Conflicts, err = p.mergeConflicts(mr.Source, mr.Target)
+	if err != nil {
+		return nil, err
+	}
+	status.Mergeable = len(status.Conflicts) == 0
+	return status, nil
+}
+
+// mergeConflicts tries to merge source into target in the local copy and
+// returns conflicting paths. Nothing is committed.
+func (p *pacakRepo) mergeConflicts(source, target string) ([]errors.Conflict, error) {
+	defer p.lockLocalCopy()()
+	if err := p.prepareLocalBranch(target); err != nil {
+		return nil, err
+	}
+	sourceRev, err := p.localRev(source)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is committed, but git requires committer identity anyway.
+	sig := git.Signature{Name: "pacak", Email: "pacak@kuberlab.com"}
+	_, err = git.NewCommand("merge", "--no-commit", "--no-ff", sourceRev).AddEnvs(signatureEnvs(sig)...).RunInDir(p.LocalPath)
+	conflicts, cerr := p.abortConflicts("merge")
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err != nil && len(conflicts) == 0 {
+		return nil, fmt.Errorf("git merge %s: %v", source, err)
+	}
+	return conflicts, nil
+}
+
+// WriteMergeRequestDiff writes unified diff of changes the merge request brings to target.
+func (p *pacakRepo) WriteMergeRequestDiff(w io.Writer, id int64) error {
+	status, err := p.MergeRequestStatus(id)
+	if err != nil {
+		return err
+	}
+	base := status.MergeBase
+	if base == "" {
+		base = emptyTreeID
+	}
+	return p.WritePatch(w, base, status.SourceCommit, PatchDiff)
+}
+
+// MergeMergeRequest merges open merge request if it has enough approvals
+// of the current source head.
+func (p *pacakRepo) MergeMergeRequest(committer git.Signature, id int64, strategy MergeStrategy, message string) (*MergeRequest, error) {
+	mergeRequestPool.CheckIn(p.R.Path)
+	defer mergeRequestPool.CheckOut(p.R.Path)
+	mr, err := p.readMergeRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if mr.State != MergeRequestOpen {
+		return nil, errors.MergeRequestNotOpen{ID: mr.ID, State: mr.State}
+	}
+	source := p.refID(git.BRANCH_PREFIX + mr.Source)
+	if source == "" {
+		return nil, errors.BranchNotFound{Name: mr.Source}
+	}
+	rules, err := p.Protection()
+	if err != nil {
+		return nil, err
+	}
+	if required, actual := rules.requiredApprovals(mr.Target), validApprovals(mr, source); actual < required {
+		return nil, errors.NotEnoughApprovals{ID: mr.ID, Required: required, Actual: actual}
+	}
+	if message == "" {
+		message = fmt.Sprintf("Merge request #%d: %s", mr.ID, mr.Title)
+	}
+	target := p.refID(git.BRANCH_PREFIX + mr.Target)
+	// Merge the approved commit even if the source branch has moved meanwhile.
+	commit, err := p.merge(source, mr.Target, strategy, committer, message, mergeRequestAction)
+	if err != nil {
+		return nil, err
+	}
+	mr.State = MergeRequestMerged
+	mr.SourceCommit = source
+	mr.TargetCommit = target
+	mr.MergeCommit = commit
+	mr.ClosedBy = signatureString(committer)
+	if err := p.writeMergeRequest(mr); err != nil {
+		return nil, err
+	}
+	return mr, nil
+}
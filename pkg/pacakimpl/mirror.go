@@ -0,0 +1,272 @@
+package pacakimpl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+	"github.com/kuberlab/pacak/pkg/sync"
+)
+
+const (
+	mirrorAction = "mirror"
+	// DefaultMirrorInterval is used if mirror interval is not set.
+	DefaultMirrorInterval = 3600
+	minMirrorInterval     = 60
+)
+
+// mirrorTimeout limits a single fetch of mirror.
+var mirrorTimeout = time.Hour
+
+var mirrorPool = sync.NewExclusivePool()
+
+// MirrorConfig makes repository a read-only mirror of remote URL.
+// All branches and tags of the remote are fetched, refs removed
+// from the remote are removed from the mirror.
+type MirrorConfig struct {
+	// URL is file://, http:// or https:// URL of the remote.
+	URL string `json:"url"`
+	// Interval is time between syncs in seconds.
+	Interval int `json:"interval"`
+}
+
+// MirrorStatus is the result of the last mirror sync.
+type MirrorStatus struct {
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Updated is number of refs changed by the last sync.
+	Updated int `json:"updated"`
+}
+
+// Public returns config without password of URL.
+func (c MirrorConfig) Public() MirrorConfig {
+	c.URL = publicURL(c.URL)
+	return c
+}
+
+// publicURL hides password of remote URL.
+func publicURL(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return remote
+}
+
+// validateRemoteURL allows file, http and https remotes only.
+func validateRemoteURL(remote string) error {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return fmt.Errorf("Invalid remote URL: %v", err)
+	}
+	switch u.Scheme {
+	case "file", "http", "https":
+		return nil
+	}
+	return fmt.Errorf("Remote URL scheme must be file, http or https")
+}
+
+// Due reports whether mirror must be synced according to its interval.
+func (c MirrorConfig) Due(status *MirrorStatus, now time.Time) bool {
+	if status.LastSync == nil {
+		return true
+	}
+	return now.Sub(*status.LastSync) >= time.Duration(c.Interval)*time.Second
+}
+
+// Validate checks config and sets default interval.
+func (c *MirrorConfig) Validate() error {
+	if err := validateRemoteURL(c.URL); err != nil {
+		return err
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultMirrorInterval
+	}
+	if c.Interval < minMirrorInterval {
+		return fmt.Errorf("Mirror interval must be at least %d seconds", minMirrorInterval)
+	}
+	return nil
+}
+
+// InitMirror creates empty repository mirroring c.URL. It is filled by
+// the first sync.
+func (g gitInterface) InitMirror(repo string, c MirrorConfig) error {
+	if g.ExistsRepository(repo) {
+		return errors.RepositoryAlreadyExists{Name: repo}
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := git.InitRepository(g.path(repo), true); err != nil {
+		return fmt.Errorf("InitRepository: %v", err)
+	}
+	r, err := g.GetRepository(repo)
+	if err == nil {
+		err = r.SetMirror(&c)
+	}
+	if err != nil {
+		os.RemoveAll(g.path(repo))
+	}
+	return err
+}
+
+// Mirror returns mirror config of the repository or nil if it is not a mirror.
+func (p *pacakRepo) Mirror() (*MirrorConfig, error) {
+	data, err := ioutil.ReadFile(p.metaPath("mirror.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &MirrorConfig{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Failed read mirror config - %v", err)
+	}
+	return c, nil
+}
+
+// SetMirror makes repository a mirror. Nil config makes it a regular repository.
+func (p *pacakRepo) SetMirror(c *MirrorConfig) error {
+	if c == nil {
+		err := os.Remove(p.metaPath("mirror.json"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.metaPath("mirror.json"), data)
+}
+
+// MirrorStatus returns the result of the last sync.
+func (p *pacakRepo) MirrorStatus() (*MirrorStatus, error) {
+	status := &MirrorStatus{}
+	data, err := ioutil.ReadFile(p.metaPath("mirror-status.json"))
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("Failed read mirror status - %v", err)
+	}
+	return status, nil
+}
+
+// SyncMirror fetches all branches and tags of the remote and records
+// changed refs. Status of the sync is saved and returned.
+func (p *pacakRepo) SyncMirror(ctx context.Context, committer git.Signature) (*MirrorStatus, error) {
+	mirrorPool.CheckIn(p.R.Path)
+	defer mirrorPool.CheckOut(p.R.Path)
+	c, err := p.Mirror()
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, fmt.Errorf("Repository %s is not a mirror", p.name)
+	}
+	status, err := p.MirrorStatus()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	status.LastSync = &now
+	status.Updated = 0
+	err = p.fetchMirror(ctx, c, &committer, &status.Updated)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Error = ""
+		status.LastSuccess = &now
+	}
+	data, merr := json.Marshal(status)
+	if merr != nil {
+		return nil, merr
+	}
+	if werr := writeFileAtomic(p.metaPath("mirror-status.json"), data); werr != nil {
+		return nil, werr
+	}
+	return status, err
+}
+
+func (p *pacakRepo) fetchMirror(ctx context.Context, c *MirrorConfig, committer *git.Signature, updated *int) error {
+	before, err := p.rawRefs()
+	if err != nil {
+		return err
+	}
+	_, stderr, err := process.ExecDirContext(ctx, mirrorTimeout, p.R.Path,
+		fmt.Sprintf("SyncMirror(git fetch): %s", p.name),
+		"git", "-c", "protocol.allow=never", "-c", "protocol.file.allow=always",
+		"-c", "protocol.http.allow=always", "-c", "protocol.https.allow=always",
+		"fetch", "--prune", "--no-tags", c.URL,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return fmt.Errorf("git fetch: %v - %s", err, strings.TrimSpace(stderr))
+	}
+	after, err := p.rawRefs()
+	if err != nil {
+		return err
+	}
+	// Follow default branch of the remote after the first sync.
+	if p.refID("HEAD") == "" {
+		branches := []string{}
+		for ref := range after {
+			if strings.HasPrefix(ref, git.BRANCH_PREFIX) {
+				branches = append(branches, ref)
+			}
+		}
+		if len(branches) > 0 {
+			sort.Strings(branches)
+			git.NewCommand("symbolic-ref", "HEAD", importHead(p.R.Path, c.URL, branches)).RunInDir(p.R.Path)
+		}
+	}
+	for ref, old := range before {
+		if after[ref] == "" {
+			p.recordRefUpdate(ref, old, "", mirrorAction, committer)
+			*updated++
+		}
+	}
+	for ref, new := range after {
+		if before[ref] != new {
+			p.recordRefUpdate(ref, before[ref], new, mirrorAction, committer)
+			*updated++
+		}
+	}
+	return nil
+}
+
+// rawRefs returns object IDs of branches and tags without peeling.
+func (p *pacakRepo) rawRefs() (map[string]string, error) {
+	output, err := git.NewCommand("for-each-ref", "--format=%(objectname) %(refname)",
+		git.BRANCH_PREFIX, git.TAG_PREFIX).RunInDir(p.R.Path)
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %v", err)
+	}
+	refs := map[string]string{}
+	for _, l := range strings.Split(output, "\n") {
+		if fields := strings.Fields(l); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,114 @@
+package pacakimpl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+type PatchFormat string
+
+const (
+	// PatchMbox is 'git format-patch' output with a message per commit.
+	PatchMbox PatchFormat = "patch"
+	// PatchDiff is plain unified diff.
+	PatchDiff PatchFormat = "diff"
+)
+
+// emptyTreeID is ID of the tree without entries, used as parent of root commits.
+const emptyTreeID = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// WritePatch writes changes between base and head revisions to w.
+// If base is empty, changes of the head commit itself are written.
+// Binary changes are included as git binary diffs.
+func (p *pacakRepo) WritePatch(w io.Writer, base, head string, format PatchFormat) error {
+	headID := p.refID(head + "^{commit}")
+	if headID == "" {
+		return errors.RevisionNotFound{Rev: head}
+	}
+	baseID := emptyTreeID
+	if base != "" {
+		if baseID = p.refID(base + "^{commit}"); baseID == "" {
+			return errors.RevisionNotFound{Rev: base}
+		}
+	} else if parent := p.refID(headID + "^1"); parent != "" {
+		baseID = parent
+	}
+
+	var cmd *git.Command
+	switch format {
+	case PatchMbox, "":
+		cmd = git.NewCommand("format-patch", "--stdout", "--binary")
+		if base == "" {
+			cmd.AddArguments("--root", "-1", headID)
+		} else {
+			cmd.AddArguments(baseID + ".." + headID)
+		}
+	case PatchDiff:
+		cmd = git.NewCommand("diff", "--binary", baseID, headID)
+	default:
+		return fmt.Errorf("unknown patch format '%s'", format)
+	}
+	stderr := new(bytes.Buffer)
+	if err := cmd.RunInDirPipeline(p.R.Path, w, stderr); err != nil {
+		return fmt.Errorf("%v: %v - %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ApplyPatch applies unified diff or 'git format-patch' mbox to branch head.
+// Commits of mbox keep authors and messages of the patch. Plain diff is
+// committed as a single commit with given message authored by committer.
+func (p *pacakRepo) ApplyPatch(committer git.Signature, branch string, patch []byte, message string) (string, error) {
+	defer p.lockLocalCopy()()
+	if branch == "" {
+		branch = "master"
+	}
+	if err := p.prepareLocalBranch(branch); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "pacak-patch-")
+	if err != nil {
+		return "", fmt.Errorf("Failed create patch file - %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(patch)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("Failed write patch file - %v", err)
+	}
+
+	if bytes.HasPrefix(patch, []byte("From ")) {
+		_, err = git.NewCommand("am", "--keep-cr", f.Name()).AddEnvs(
+			"GIT_COMMITTER_NAME="+committer.Name,
+			"GIT_COMMITTER_EMAIL="+committer.Email,
+		).RunInDir(p.LocalPath)
+		if err != nil {
+			git.NewCommand("am", "--abort").RunInDir(p.LocalPath)
+			if rerr := git.ResetHEAD(p.LocalPath, true, "origin/"+branch); rerr != nil {
+				return "", fmt.Errorf("git reset --hard origin/%s: %v", branch, rerr)
+			}
+			return "", errors.PatchFailed{Reason: err.Error()}
+		}
+	} else {
+		if _, err = git.NewCommand("apply", "--index", f.Name()).RunInDir(p.LocalPath); err != nil {
+			return "", errors.PatchFailed{Reason: err.Error()}
+		}
+		if message == "" {
+			message = "Apply patch"
+		}
+		if err = git.CommitChanges(p.LocalPath, git.CommitChangesOptions{
+			Committer: &committer,
+			Message:   message,
+		}); err != nil {
+			return "", fmt.Errorf("CommitChanges: %v", err)
+		}
+	}
+	return p.pushLocal(branch, "apply-patch", committer)
+}
@@ -0,0 +1,182 @@
+package pacakimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+// ProtectionRules restrict updates of branches and tags of a repository.
+type ProtectionRules struct {
+	Branches []BranchProtection `json:"branches"`
+	// ImmutableTags are tag name patterns. Matching tags can be created
+	// but never moved or deleted.
+	ImmutableTags []string `json:"immutableTags"`
+}
+
+// BranchProtection restricts updates of branches matching Pattern.
+type BranchProtection struct {
+	Pattern string `json:"pattern"`
+	// DenyForcePush rejects clean pushes and updates which are not
+	// fast-forward, such as reset or undo.
+	DenyForcePush bool `json:"denyForcePush"`
+	// DenyDirectCommits rejects all updates except merges.
+	DenyDirectCommits bool `json:"denyDirectCommits"`
+	// RequiredApprovals is the number of approvals a merge request needs
+	// to be merged. If set, branch can not be merged into directly.
+	RequiredApprovals int `json:"requiredApprovals,omitempty"`
+}
+
+func (r *ProtectionRules) validate() error {
+	patterns := append([]string{}, r.ImmutableTags...)
+	for _, b := range r.Branches {
+		if b.RequiredApprovals < 0 {
+			return fmt.Errorf("invalid number of required approvals %d", b.RequiredApprovals)
+		}
+		patterns = append(patterns, b.Pattern)
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return fmt.Errorf("invalid pattern '%s'", p)
+		}
+	}
+	return nil
+}
+
+// Protection returns protection rules of the repository.
+func (p *pacakRepo) Protection() (*ProtectionRules, error) {
+	rules := &ProtectionRules{
+		Branches:      []BranchProtection{},
+		ImmutableTags: []string{},
+	}
+	data, err := ioutil.ReadFile(p.metaPath("protection.json"))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("Failed read protection rules - %v", err)
+	}
+	return rules, nil
+}
+
+// SetProtection replaces protection rules of the repository.
+// Immutable tag patterns once set can not be removed or changed.
+func (p *pacakRepo) SetProtection(rules ProtectionRules) error {
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	defer p.lockLocalCopy()()
+	current, err := p.Protection()
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, pattern := range rules.ImmutableTags {
+		kept[pattern] = true
+	}
+	for _, pattern := range current.ImmutableTags {
+		if !kept[pattern] {
+			return fmt.Errorf("immutable tags pattern '%s' can not be removed", pattern)
+		}
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.metaPath("protection.json"), data)
+}
+
+// checkProtection returns errors.ProtectedRef if the ref may not be updated
+// from old to new value by given action. Empty old or new value means
+// the ref is created or deleted. isAncestor tells whether old is an
+// ancestor of new.
+func (p *pacakRepo) checkProtection(ref, old, new, action string, isAncestor func(old, new string) bool) error {
+	// Mirrors are updated by sync only.
+	if util.IsExist(p.metaPath("mirror.json")) {
+		return errors.ProtectedRef{Ref: ref, Reason: "repository is a mirror"}
+	}
+	rules, err := p.Protection()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(ref, git.TAG_PREFIX) {
+		tag := strings.TrimPrefix(ref, git.TAG_PREFIX)
+		for _, pattern := range rules.ImmutableTags {
+			if ok, _ := path.Match(pattern, tag); ok && old != "" && old != new {
+				return errors.ProtectedRef{Ref: ref, Reason: "tag is immutable"}
+			}
+		}
+		return nil
+	}
+	branch := strings.TrimPrefix(ref, git.BRANCH_PREFIX)
+	for _, b := range rules.Branches {
+		if ok, _ := path.Match(b.Pattern, branch); !ok {
+			continue
+		}
+		if b.DenyDirectCommits && action != "merge" && action != mergeRequestAction {
+			return errors.ProtectedRef{Ref: ref, Reason: "direct commits are not allowed, use merge"}
+		}
+		if b.RequiredApprovals > 0 && action == "merge" {
+			return errors.ProtectedRef{Ref: ref, Reason: "merge requires approved merge request"}
+		}
+		if !b.DenyForcePush || old == "" {
+			continue
+		}
+		if action == "clean-push" {
+			return errors.ProtectedRef{Ref: ref, Reason: "clean push is not allowed"}
+		}
+		if new == "" || !isAncestor(old, new) {
+			return errors.ProtectedRef{Ref: ref, Reason: "force push is not allowed"}
+		}
+	}
+	return nil
+}
+
+// requiredApprovals returns number of approvals required to merge into branch.
+func (r *ProtectionRules) requiredApprovals(branch string) int {
+	required := 0
+	for _, b := range r.Branches {
+		if ok, _ := path.Match(b.Pattern, branch); ok && b.RequiredApprovals > required {
+			required = b.RequiredApprovals
+		}
+	}
+	return required
+}
+
+// checkRefUpdate checks protection of the ref updated directly in the bare repository.
+func (p *pacakRepo) checkRefUpdate(ref, old, new, action string) error {
+	return p.checkProtection(ref, old, new, action, func(old, new string) bool {
+		_, err := git.NewCommand("merge-base", "--is-ancestor", old, new).RunInDir(p.R.Path)
+		return err == nil
+	})
+}
+
+// beforePush checks HEAD of the local copy which is about to be pushed
+// to branch. The local branch is moved back if the push is rejected.
+func (p *pacakRepo) beforePush(branch, action string) error {
+	ref := git.BRANCH_PREFIX + branch
+	old := p.refID(ref)
+	output, err := git.NewCommand("rev-parse", "HEAD").RunInDir(p.LocalPath)
+	if err != nil {
+		return fmt.Errorf("git rev-parse HEAD: %v", err)
+	}
+	head := strings.TrimSpace(output)
+	err = p.checkProtection(ref, old, head, action, p.isAncestor)
+	if err == nil {
+		err = p.validatePush(branch, old, head)
+	}
+	if err != nil && old != "" {
+		git.ResetHEAD(p.LocalPath, true, old)
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package pacakimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+	"github.com/kuberlab/pacak/pkg/sync"
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+var pushMirrorPool = sync.NewExclusivePool()
+
+// PushMirror is a downstream remote all branches and tags are pushed to
+// after they change. Refs removed from the repository are removed from
+// the remote as well.
+type PushMirror struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	// Pending is set when refs changed since the last successful push.
+	Pending     bool       `json:"pending"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Attempts is the number of failed pushes since the last success.
+	Attempts int `json:"attempts"`
+}
+
+// Public returns push mirror without password of URL.
+func (m PushMirror) Public() PushMirror {
+	m.URL = publicURL(m.URL)
+	return m
+}
+
+func (p *pacakRepo) readPushMirrors() ([]*PushMirror, error) {
+	mirrors := []*PushMirror{}
+	data, err := ioutil.ReadFile(p.metaPath("push-mirrors.json"))
+	if os.IsNotExist(err) {
+		return mirrors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &mirrors); err != nil {
+		return nil, fmt.Errorf("Failed read push mirrors - %v", err)
+	}
+	return mirrors, nil
+}
+
+// updatePushMirrors reads push mirrors, calls f and writes them back if f succeeds.
+func (p *pacakRepo) updatePushMirrors(f func(mirrors []*PushMirror) ([]*PushMirror, error)) error {
+	pushMirrorPool.CheckIn(p.R.Path)
+	defer pushMirrorPool.CheckOut(p.R.Path)
+	mirrors, err := p.readPushMirrors()
+	if err != nil {
+		return err
+	}
+	if mirrors, err = f(mirrors); err != nil {
+		return err
+	}
+	data, err := json.Marshal(mirrors)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.metaPath("push-mirrors.json"), data)
+}
+
+// PushMirrors returns push mirrors of the repository with their status.
+func (p *pacakRepo) PushMirrors() ([]PushMirror, error) {
+	pushMirrorPool.CheckIn(p.R.Path)
+	defer pushMirrorPool.CheckOut(p.R.Path)
+	mirrors, err := p.readPushMirrors()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]PushMirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		res = append(res, *m)
+	}
+	return res, nil
+}
+
+// AddPushMirror adds downstream remote. It is pending until the first push.
+func (p *pacakRepo) AddPushMirror(url string) (*PushMirror, error) {
+	if err := validateRemoteURL(url); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	m := &PushMirror{
+		ID:      hex.EncodeToString(b),
+		URL:     url,
+		Created: time.Now(),
+		Pending: true,
+	}
+	err := p.updatePushMirrors(func(mirrors []*PushMirror) ([]*PushMirror, error) {
+		return append(mirrors, m), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeletePushMirror removes downstream remote. The remote itself is not changed.
+func (p *pacakRepo) DeletePushMirror(id string) error {
+	return p.updatePushMirrors(func(mirrors []*PushMirror) ([]*PushMirror, error) {
+		for i, m := range mirrors {
+			if m.ID == id {
+				return append(mirrors[:i], mirrors[i+1:]...), nil
+			}
+		}
+		return nil, errors.PushMirrorNotFound{ID: id}
+	})
+}
+
+// SetPushMirrorsPending marks all push mirrors as pending after refs changed.
+// It returns IDs of push mirrors.
+func (p *pacakRepo) SetPushMirrorsPending() ([]string, error) {
+	ids := []string{}
+	if !util.IsExist(p.metaPath("push-mirrors.json")) {
+		return ids, nil
+	}
+	err := p.updatePushMirrors(func(mirrors []*PushMirror) ([]*PushMirror, error) {
+		for _, m := range mirrors {
+			m.Pending = true
+			ids = append(ids, m.ID)
+		}
+		return mirrors, nil
+	})
+	return ids, err
+}
+
+// SyncPushMirror pushes all branches and tags to the push mirror and
+// records the result. The push mirror stays pending if the push fails
+// or refs changed while pushing.
+func (p *pacakRepo) SyncPushMirror(ctx context.Context, id string) (*PushMirror, error) {
+	var url string
+	err := p.updatePushMirrors(func(mirrors []*PushMirror) ([]*PushMirror, error) {
+		for _, m := range mirrors {
+			if m.ID == id {
+				url = m.URL
+				m.Pending = false
+				return mirrors, nil
+			}
+		}
+		return nil, errors.PushMirrorNotFound{ID: id}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, stderr, pushErr := process.ExecDirContext(ctx, mirrorTimeout, p.R.Path,
+		fmt.Sprintf("SyncPushMirror(git push): %s", p.name),
+		"git", "-c", "protocol.allow=never", "-c", "protocol.file.allow=always",
+		"-c", "protocol.http.allow=always", "-c", "protocol.https.allow=always",
+		"push", "--porcelain", "--prune", url,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	if pushErr != nil {
+		pushErr = fmt.Errorf("git push: %v - %s", pushErr, strings.TrimSpace(stderr))
+	}
+
+	var res PushMirror
+	err = p.updatePushMirrors(func(mirrors []*PushMirror) ([]*PushMirror, error) {
+		for _, m := range mirrors {
+			if m.ID != id {
+				continue
+			}
+			now := time.Now()
+			m.LastAttempt = &now
+			if pushErr != nil {
+				m.Pending = true
+				m.Attempts++
+				m.Error = pushErr.Error()
+			} else {
+				m.LastSuccess = &now
+				m.Attempts = 0
+				m.Error = ""
+			}
+			res = *m
+			return mirrors, nil
+		}
+		return nil, errors.PushMirrorNotFound{ID: id}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, pushErr
+}
@@ -0,0 +1,294 @@
+package pacakimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+// usageTTL is how long computed usage of a repository is reused for
+// quota checks. Usage is recomputed after refs of the repository change.
+const usageTTL = time.Minute
+
+// Quotas limit disk usage of repositories and namespaces in bytes. Usage
+// of a repository is the size of its bare repository and its local copy.
+// Zero limit means unlimited.
+//
+// Quotas are checked only when repositories are created with
+// InitRepository and when files are written with Save or CheckoutAndSave,
+// and only if the write adds data. Git pushes, imports, forks, mirror
+// syncs, merges, reverts, patches and CleanPush are not limited.
+type Quotas struct {
+	// Repo is the limit of repositories not listed in Repos.
+	Repo int64 `json:"repo"`
+	// Namespace is the limit of namespaces not listed in Namespaces.
+	Namespace  int64            `json:"namespace"`
+	Repos      map[string]int64 `json:"repos,omitempty"`
+	Namespaces map[string]int64 `json:"namespaces,omitempty"`
+}
+
+// RepoLimit returns quota of the repository.
+func (q Quotas) RepoLimit(repo string) int64 {
+	if limit, ok := q.Repos[repo]; ok {
+		return limit
+	}
+	return q.Repo
+}
+
+// NamespaceLimit returns quota of the namespace.
+func (q Quotas) NamespaceLimit(ns string) int64 {
+	if limit, ok := q.Namespaces[ns]; ok {
+		return limit
+	}
+	return q.Namespace
+}
+
+func (q Quotas) validate() error {
+	if q.Repo < 0 || q.Namespace < 0 {
+		return fmt.Errorf("Quota can not be negative")
+	}
+	for name, limit := range q.Repos {
+		if limit < 0 {
+			return fmt.Errorf("Quota of %s can not be negative", name)
+		}
+	}
+	for name, limit := range q.Namespaces {
+		if limit < 0 {
+			return fmt.Errorf("Quota of %s can not be negative", name)
+		}
+	}
+	return nil
+}
+
+// LoadQuotas reads quotas from JSON file.
+func LoadQuotas(filePath string) (Quotas, error) {
+	q := Quotas{}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(data, &q); err != nil {
+		return q, fmt.Errorf("Failed parse quotas - %v", err)
+	}
+	return q, nil
+}
+
+// Usage is disk usage of a repository in bytes.
+type Usage struct {
+	Repo  string `json:"repo"`
+	Bare  int64  `json:"bare"`
+	Local int64  `json:"local"`
+	Total int64  `json:"total"`
+	Limit int64  `json:"limit"`
+}
+
+// NamespaceUsage is disk usage of all repositories of a namespace in bytes.
+type NamespaceUsage struct {
+	Namespace string  `json:"namespace"`
+	Total     int64   `json:"total"`
+	Limit     int64   `json:"limit"`
+	Repos     []Usage `json:"repos"`
+}
+
+type cachedUsage struct {
+	Usage
+	at time.Time
+}
+
+type quotaState struct {
+	gitRoot   string
+	localRoot string
+
+	lock   gosync.Mutex
+	quotas Quotas
+	usage  map[string]cachedUsage
+}
+
+func newQuotaState(gitRoot, localRoot string) *quotaState {
+	return &quotaState{
+		gitRoot:   gitRoot,
+		localRoot: localRoot,
+		usage:     make(map[string]cachedUsage),
+	}
+}
+
+func (q *quotaState) invalidate(repo string, _ RefUpdate) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.usage, repo)
+}
+
+// repoUsage returns usage of repo computing it if cached one is older than maxAge.
+func (q *quotaState) repoUsage(repo string, maxAge time.Duration) (Usage, error) {
+	q.lock.Lock()
+	c, ok := q.usage[repo]
+	limit := q.quotas.RepoLimit(repo)
+	q.lock.Unlock()
+	if ok && time.Since(c.at) < maxAge {
+		c.Limit = limit
+		return c.Usage, nil
+	}
+	u := Usage{Repo: repo, Limit: limit}
+	var err error
+	if u.Bare, err = dirSize(path.Join(q.gitRoot, repo)); err != nil {
+		return u, err
+	}
+	if u.Local, err = dirSize(path.Join(q.localRoot, repo)); err != nil {
+		return u, err
+	}
+	u.Total = u.Bare + u.Local
+	q.lock.Lock()
+	q.usage[repo] = cachedUsage{Usage: u, at: time.Now()}
+	q.lock.Unlock()
+	return u, nil
+}
+
+func (q *quotaState) namespaceUsage(ns string, maxAge time.Duration) (*NamespaceUsage, error) {
+	repos, err := gitInterface{gitRoot: q.gitRoot}.ListRepositories()
+	if err != nil {
+		return nil, err
+	}
+	q.lock.Lock()
+	res := &NamespaceUsage{Namespace: ns, Limit: q.quotas.NamespaceLimit(ns), Repos: []Usage{}}
+	q.lock.Unlock()
+	for _, repo := range repos {
+		if path.Dir(repo) != ns {
+			continue
+		}
+		u, err := q.repoUsage(repo, maxAge)
+		if err != nil {
+			return nil, err
+		}
+		res.Total += u.Total
+		res.Repos = append(res.Repos, u)
+	}
+	return res, nil
+}
+
+// check returns QuotaExceeded if adding size bytes to repo exceeds its
+// quota or quota of its namespace.
+func (q *quotaState) check(repo string, size int64) error {
+	// Writes which add no data are allowed to get back under the quota.
+	if size <= 0 {
+		return nil
+	}
+	q.lock.Lock()
+	repoLimit := q.quotas.RepoLimit(repo)
+	nsLimit := q.quotas.NamespaceLimit(path.Dir(repo))
+	q.lock.Unlock()
+	if repoLimit > 0 {
+		u, err := q.repoUsage(repo, usageTTL)
+		if err != nil {
+			return err
+		}
+		if u.Total+size > repoLimit {
+			return errors.QuotaExceeded{Scope: "repository", Name: repo, Usage: u.Total, Size: size, Limit: repoLimit}
+		}
+	}
+	if nsLimit > 0 {
+		u, err := q.namespaceUsage(path.Dir(repo), usageTTL)
+		if err != nil {
+			return err
+		}
+		if u.Total+size > nsLimit {
+			return errors.QuotaExceeded{Scope: "namespace", Name: u.Namespace, Usage: u.Total, Size: size, Limit: nsLimit}
+		}
+	}
+	return nil
+}
+
+// dirSize returns total size of regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func filesSize(files []GitFile) int64 {
+	var size int64
+	for _, f := range files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// SetQuotas replaces disk quotas.
+func (g gitInterface) SetQuotas(quotas Quotas) error {
+	if err := quotas.validate(); err != nil {
+		return err
+	}
+	g.quota.lock.Lock()
+	defer g.quota.lock.Unlock()
+	g.quota.quotas = quotas
+	return nil
+}
+
+// Quotas returns disk quotas.
+func (g gitInterface) Quotas() Quotas {
+	g.quota.lock.Lock()
+	defer g.quota.lock.Unlock()
+	return g.quota.quotas
+}
+
+// Usage computes disk usage of the repository.
+func (g gitInterface) Usage(repo string) (*Usage, error) {
+	if !g.ExistsRepository(repo) {
+		return nil, errors.RepositoryNotFound{Name: repo}
+	}
+	u, err := g.quota.repoUsage(repo, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// NamespaceUsages returns disk usage of all namespaces. Usage computed
+// recently for quota checks may be reused.
+func (g gitInterface) NamespaceUsages() ([]NamespaceUsage, error) {
+	repos, err := g.ListRepositories()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, repo := range repos {
+		if ns := path.Dir(repo); !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	res := make([]NamespaceUsage, 0, len(namespaces))
+	for _, ns := range namespaces {
+		u, err := g.quota.namespaceUsage(ns, usageTTL)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *u)
+	}
+	return res, nil
+}
+
+// NamespaceUsage returns disk usage of the namespace.
+func (g gitInterface) NamespaceUsage(ns string) (*NamespaceUsage, error) {
+	return g.quota.namespaceUsage(ns, usageTTL)
+}
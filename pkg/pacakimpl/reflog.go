@@ -0,0 +1,214 @@
+package pacakimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/sync"
+	"github.com/sirupsen/logrus"
+)
+
+// reflogSize is the number of ref updates kept in the reflog.
+const reflogSize = 100
+
+// reflogRefPrefix keeps previous values of refs reachable, so they survive gc.
+const reflogRefPrefix = "refs/pacak/reflog/"
+
+const emptyID = "0000000000000000000000000000000000000000"
+
+var reflogPool = sync.NewExclusivePool()
+
+// metaPath returns path of pacak metadata stored inside the bare repository.
+func (p *pacakRepo) metaPath(name ...string) string {
+	return path.Join(append([]string{p.R.Path, "pacak"}, name...)...)
+}
+
+// refID returns object ID the ref points to or empty string if ref does not exist.
+func (p *pacakRepo) refID(ref string) string {
+	id, err := git.NewCommand("rev-parse", "--verify", "-q", ref).RunInDir(p.R.Path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(id)
+}
+
+func (p *pacakRepo) readReflog() ([]RefUpdate, error) {
+	data, err := ioutil.ReadFile(p.metaPath("reflog.json"))
+	if os.IsNotExist(err) {
+		return []RefUpdate{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log := []RefUpdate{}
+	if err = json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("Failed read reflog - %v", err)
+	}
+	return log, nil
+}
+
+func (p *pacakRepo) writeReflog(log []RefUpdate) error {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.metaPath("reflog.json"), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to filePath.
+func writeFileAtomic(filePath string, data []byte) error {
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// recordRefUpdate appends ref update to the reflog. The update has already
+// happened at this point, so failures are only logged.
+func (p *pacakRepo) recordRefUpdate(ref, old, new, action string, committer *git.Signature) {
+	if old == new {
+		return
+	}
+	reflogPool.CheckIn(p.R.Path)
+	defer reflogPool.CheckOut(p.R.Path)
+
+	u := RefUpdate{
+		ID:     1,
+		Ref:    ref,
+		Old:    old,
+		New:    new,
+		Action: action,
+		When:   time.Now(),
+	}
+	if committer != nil {
+		u.Committer = fmt.Sprintf("%s <%s>", committer.Name, committer.Email)
+	}
+	// Listeners are notified even if the update could not be recorded.
+	defer func() { p.listeners.notify(p.name, u) }()
+
+	log, err := p.readReflog()
+	if err != nil {
+		logrus.Errorf("Failed record ref update of %v: %v", ref, err)
+		return
+	}
+	if len(log) > 0 {
+		u.ID = log[len(log)-1].ID + 1
+	}
+	if old != "" {
+		if _, err := git.NewCommand("update-ref", fmt.Sprintf("%s%d", reflogRefPrefix, u.ID), old).RunInDir(p.R.Path); err != nil {
+			logrus.Errorf("Failed keep previous value of %v: %v", ref, err)
+		}
+	}
+	log = append(log, u)
+	for len(log) > reflogSize {
+		git.NewCommand("update-ref", "-d", fmt.Sprintf("%s%d", reflogRefPrefix, log[0].ID)).RunInDir(p.R.Path)
+		log = log[1:]
+	}
+	if err := p.writeReflog(log); err != nil {
+		logrus.Errorf("Failed record ref update of %v: %v", ref, err)
+	}
+}
+
+// RefLog returns recent updates of given ref (or of all refs if ref is empty), newest first.
+func (p *pacakRepo) RefLog(ref string, limit int) ([]RefUpdate, error) {
+	reflogPool.CheckIn(p.R.Path)
+	log, err := p.readReflog()
+	reflogPool.CheckOut(p.R.Path)
+	if err != nil {
+		return nil, err
+	}
+	res := []RefUpdate{}
+	for i := len(log) - 1; i >= 0; i-- {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+		if ref == "" || log[i].Ref == ref {
+			res = append(res, log[i])
+		}
+	}
+	return res, nil
+}
+
+// ResetBranch moves branch to given commit. Previous head is recorded
+// in the reflog and can be restored with UndoRefUpdate.
+func (p *pacakRepo) ResetBranch(committer git.Signature, branch, commit string) (string, error) {
+	defer p.lockLocalCopy()()
+	ref := git.BRANCH_PREFIX + branch
+	old := p.refID(ref)
+	if old == "" {
+		return "", errors.BranchNotFound{Name: branch}
+	}
+	id := p.refID(commit + "^{commit}")
+	if id == "" {
+		return "", errors.RevisionNotFound{Rev: commit}
+	}
+	if err := p.checkRefUpdate(ref, old, id, "reset"); err != nil {
+		return "", err
+	}
+	if err := p.updateRef(ref, old, id); err != nil {
+		return "", err
+	}
+	p.recordRefUpdate(ref, old, id, "reset", &committer)
+	return id, nil
+}
+
+// UndoRefUpdate restores the ref to the value it had before given update.
+// The ref must not have been changed since the update.
+func (p *pacakRepo) UndoRefUpdate(committer git.Signature, id int64) (string, error) {
+	defer p.lockLocalCopy()()
+	reflogPool.CheckIn(p.R.Path)
+	log, err := p.readReflog()
+	reflogPool.CheckOut(p.R.Path)
+	if err != nil {
+		return "", err
+	}
+	var u *RefUpdate
+	for i := range log {
+		if log[i].ID == id {
+			u = &log[i]
+		}
+	}
+	if u == nil {
+		return "", errors.RefUpdateNotFound{ID: id}
+	}
+	if current := p.refID(u.Ref); current != u.New {
+		return "", errors.RefChanged{Ref: u.Ref, Expected: u.New, Actual: current}
+	}
+	if err := p.checkRefUpdate(u.Ref, u.New, u.Old, "undo"); err != nil {
+		return "", err
+	}
+	if err := p.updateRef(u.Ref, u.New, u.Old); err != nil {
+		return "", err
+	}
+	p.recordRefUpdate(u.Ref, u.New, u.Old, fmt.Sprintf("undo %d", u.ID), &committer)
+	return u.Old, nil
+}
+
+// updateRef changes ref of the bare repository from old to new value.
+// Empty old value means the ref must not exist, empty new value deletes the ref.
+func (p *pacakRepo) updateRef(ref, old, new string) error {
+	if old == "" {
+		old = emptyID
+	}
+	var cmd *git.Command
+	if new == "" {
+		cmd = git.NewCommand("update-ref", "-d", ref, old)
+	} else {
+		cmd = git.NewCommand("update-ref", ref, new, old)
+	}
+	if _, err := cmd.RunInDir(p.R.Path); err != nil {
+		return fmt.Errorf("git update-ref %s: %v", ref, err)
+	}
+	return nil
+}
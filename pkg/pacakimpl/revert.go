@@ -0,0 +1,101 @@
+package pacakimpl
+
+import (
+	"fmt"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+)
+
+// Revert records a commit on branch which reverts changes of given commit.
+// Merge commits are reverted relative to their first parent.
+func (p *pacakRepo) Revert(committer git.Signature, branch, commit string) (string, error) {
+	defer p.lockLocalCopy()()
+	if branch == "" {
+		branch = "master"
+	}
+	if err := p.prepareLocalBranch(branch); err != nil {
+		return "", err
+	}
+	rev, err := p.localRev(commit)
+	if err != nil {
+		return "", err
+	}
+	cmd := git.NewCommand("revert", "--no-edit").AddEnvs(signatureEnvs(committer)...)
+	if p.isMergeCommit(rev) {
+		cmd.AddArguments("-m", "1")
+	}
+	if _, err := cmd.AddArguments(rev).RunInDir(p.LocalPath); err != nil {
+		conflicts, cerr := p.abortConflicts("revert")
+		if cerr != nil {
+			return "", cerr
+		}
+		if len(conflicts) > 0 {
+			return "", errors.MergeConflict{Source: commit, Target: branch, Conflicts: conflicts}
+		}
+		return "", fmt.Errorf("git revert %s: %v", commit, err)
+	}
+	return p.pushLocal(branch, "revert", committer)
+}
+
+// CherryPick applies changes of given commits to target branch one by one.
+// Original authors are preserved, committer is set to given one. Either all
+// commits are applied or none of them.
+func (p *pacakRepo) CherryPick(committer git.Signature, commits []string, targetBranch string) (string, error) {
+	defer p.lockLocalCopy()()
+	if targetBranch == "" {
+		targetBranch = "master"
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("no commits to cherry-pick")
+	}
+	if err := p.prepareLocalBranch(targetBranch); err != nil {
+		return "", err
+	}
+	for _, commit := range commits {
+		rev, err := p.localRev(commit)
+		if err != nil {
+			return "", err
+		}
+		cmd := git.NewCommand("cherry-pick").AddEnvs(
+			"GIT_COMMITTER_NAME="+committer.Name,
+			"GIT_COMMITTER_EMAIL="+committer.Email,
+		)
+		if p.isMergeCommit(rev) {
+			cmd.AddArguments("-m", "1")
+		}
+		if _, err := cmd.AddArguments(rev).RunInDir(p.LocalPath); err != nil {
+			conflicts, cerr := p.abortConflicts("cherry-pick")
+			if cerr != nil {
+				return "", cerr
+			}
+			// Drop already picked commits.
+			if rerr := git.ResetHEAD(p.LocalPath, true, "origin/"+targetBranch); rerr != nil {
+				return "", fmt.Errorf("git reset --hard origin/%s: %v", targetBranch, rerr)
+			}
+			if len(conflicts) > 0 {
+				return "", errors.MergeConflict{Source: commit, Target: targetBranch, Conflicts: conflicts}
+			}
+			return "", fmt.Errorf("git cherry-pick %s: %v", commit, err)
+		}
+	}
+	return p.pushLocal(targetBranch, "cherry-pick", committer)
+}
+
+// prepareLocalBranch checks out existing branch in the local copy aligned with the server.
+func (p *pacakRepo) prepareLocalBranch(branch string) error {
+	if !git.IsBranchExist(p.R.Path, branch) {
+		return errors.BranchNotFound{Name: branch}
+	}
+	if err := p.DiscardLocalRepoBranchChanges(branch); err != nil {
+		return fmt.Errorf("DiscardLocalRepoBranchChanges [branch: %s]: %v", branch, err)
+	} else if err = p.UpdateLocalCopyBranch(branch); err != nil {
+		return fmt.Errorf("UpdateLocalCopyBranch [branch: %s]: %v", branch, err)
+	}
+	return nil
+}
+
+func (p *pacakRepo) isMergeCommit(rev string) bool {
+	_, err := git.NewCommand("rev-parse", "--verify", "-q", rev+"^2").RunInDir(p.LocalPath)
+	return err == nil
+}
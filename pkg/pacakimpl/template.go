@@ -0,0 +1,200 @@
+package pacakimpl
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/util"
+)
+
+// Repository templates are directories or git repositories under the
+// templates path. Variables like {{name}} are substituted in paths and
+// contents of text files. Built-in variables are repo (full repository
+// name), namespace and name; unknown variables are left as is.
+
+type templateConfig struct {
+	lock gosync.RWMutex
+	path string
+}
+
+func (t *templateConfig) dir() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.path
+}
+
+// SetTemplatesPath sets directory repository templates are looked up in.
+func (g gitInterface) SetTemplatesPath(dir string) {
+	g.templates.lock.Lock()
+	defer g.templates.lock.Unlock()
+	g.templates.path = dir
+}
+
+// Templates returns names of available repository templates.
+func (g gitInterface) Templates() ([]string, error) {
+	dir := g.templates.dir()
+	res := []string{}
+	if dir == "" {
+		return res, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			res = append(res, f.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// InitRepositoryFromTemplate creates repository with files of the template.
+func (g gitInterface) InitRepositoryFromTemplate(committer git.Signature, repo, template string, vars map[string]string) error {
+	files, err := g.templateFiles(repo, template, vars)
+	if err != nil {
+		return err
+	}
+	return g.InitRepository(committer, repo, files)
+}
+
+func (g gitInterface) templateFiles(repo, template string, vars map[string]string) ([]GitFile, error) {
+	root := g.templates.dir()
+	if root == "" || template == "" || template == "." || template == ".." || strings.ContainsAny(template, "/\\") {
+		return nil, errors.TemplateNotFound{Name: template}
+	}
+	dir := path.Join(root, template)
+	if !util.IsExist(dir) {
+		return nil, errors.TemplateNotFound{Name: template}
+	}
+	var files []GitFile
+	var err error
+	switch {
+	case util.IsExist(path.Join(dir, "HEAD")) && util.IsExist(path.Join(dir, "objects")):
+		files, err = gitTemplateFiles(dir)
+	case util.IsExist(path.Join(dir, ".git")):
+		files, err = gitTemplateFiles(path.Join(dir, ".git"))
+	default:
+		files, err = dirTemplateFiles(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed read template %s - %v", template, err)
+	}
+
+	builtin := map[string]string{
+		"repo":      repo,
+		"namespace": path.Dir(repo),
+		"name":      path.Base(repo),
+	}
+	replace := []string{}
+	for k, v := range builtin {
+		replace = append(replace, "{{"+k+"}}", v)
+	}
+	// Built-in variables can not be overridden.
+	for k, v := range vars {
+		if _, ok := builtin[k]; !ok {
+			replace = append(replace, "{{"+k+"}}", v)
+		}
+	}
+	r := strings.NewReplacer(replace...)
+	for i := range files {
+		p := r.Replace(files[i].Path)
+		if !validFilePath(p) {
+			return nil, errors.InvalidTemplatePath{Name: template, Path: p}
+		}
+		files[i].Path = p
+		// Binary files are copied as is.
+		if bytes.IndexByte(files[i].Data, 0) < 0 {
+			files[i].Data = []byte(r.Replace(string(files[i].Data)))
+		}
+	}
+	return files, nil
+}
+
+// gitTemplateFiles returns files of HEAD of git repository.
+func gitTemplateFiles(gitDir string) ([]GitFile, error) {
+	buf := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := git.NewCommand("archive", "--format=tar", "HEAD")
+	if err := cmd.RunInDirPipeline(gitDir, buf, stderr); err != nil {
+		return nil, fmt.Errorf("git archive: %v - %s", err, strings.TrimSpace(stderr.String()))
+	}
+	files := []GitFile{}
+	tr := tar.NewReader(buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, GitFile{
+			Path:       h.Name,
+			Data:       data,
+			Executable: h.Mode&0111 != 0,
+		})
+	}
+}
+
+// dirTemplateFiles returns regular files of directory tree.
+func dirTemplateFiles(dir string) ([]GitFile, error) {
+	files := []GitFile{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, GitFile{
+			Path:       filepath.ToSlash(rel),
+			Data:       data,
+			Executable: info.Mode()&0111 != 0,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// validFilePath reports whether p is a relative path inside the work tree.
+func validFilePath(p string) bool {
+	if p == "" || path.IsAbs(p) {
+		return false
+	}
+	p = path.Clean(p)
+	return p != "." && p != ".." && !strings.HasPrefix(p, "../") && p != ".git" && !strings.HasPrefix(p, ".git/")
+}
@@ -8,6 +8,10 @@ import (
 type GitFile struct {
 	Path string
 	Data []byte
+	// Delete removes the file (or the whole directory) at Path instead of writing Data.
+	Delete bool
+	// Executable sets executable bit of the file.
+	Executable bool
 }
 
 type Commit struct {
@@ -19,6 +23,79 @@ type Commit struct {
 	When        time.Time
 }
 
+// CommitChanges is a commit with paths it changed.
+type CommitChanges struct {
+	Commit
+	Paths []string
+}
+
+// RefUpdate is an entry of the pacak-managed reflog. Empty Old means
+// the ref was created, empty New means it was deleted.
+type RefUpdate struct {
+	ID        int64     `json:"id"`
+	Ref       string    `json:"ref"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Action    string    `json:"action"`
+	Committer string    `json:"committer,omitempty"`
+	When      time.Time `json:"when"`
+}
+
+// BlameLine tells which commit last changed a line of a file.
+type BlameLine struct {
+	Line        int       `json:"line"`
+	Commit      string    `json:"commit"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	When        time.Time `json:"when"`
+	Summary     string    `json:"summary"`
+	Content     string    `json:"content"`
+}
+
+// FileVersion is a commit which changed a file. Path is the file path
+// in that commit since the file may have been renamed.
+type FileVersion struct {
+	Commit      string    `json:"commit"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	When        time.Time `json:"when"`
+	Summary     string    `json:"summary"`
+	Status      string    `json:"status"`
+	Path        string    `json:"path"`
+	Blob        string    `json:"blob,omitempty"`
+	Size        int64     `json:"size"`
+}
+
+type GrepOptions struct {
+	Query string
+	// Regex treats Query as extended regular expression instead of literal string.
+	Regex      bool
+	IgnoreCase bool
+	// Paths limits search to files matching any of the globs.
+	Paths []string
+	// Context is the number of lines around each match to return.
+	Context int
+	Offset  int
+	Limit   int
+}
+
+type GrepMatch struct {
+	Path    string   `json:"path"`
+	Line    int      `json:"line"`
+	Content string   `json:"content"`
+	Before  []string `json:"before,omitempty"`
+	After   []string `json:"after,omitempty"`
+}
+
+// GrepResult is a page of matches. Total counts matches found, search
+// stops after a fixed number of matches, which is reported by Truncated.
+type GrepResult struct {
+	Matches    []GrepMatch `json:"matches"`
+	Total      int         `json:"total"`
+	Truncated  bool        `json:"truncated"`
+	NextOffset int         `json:"nextOffset,omitempty"`
+}
+
 type CommitSorter []Commit
 
 func (s CommitSorter) Len() int {
@@ -32,6 +109,7 @@ func (s CommitSorter) Swap(i, j int) {
 }
 
 type GitFileInfo struct {
+	id      string
 	dir     bool
 	name    string
 	size    int64
@@ -45,3 +123,6 @@ func (fs *GitFileInfo) Size() int64        { return fs.size }
 func (fs *GitFileInfo) Mode() os.FileMode  { return fs.mode }
 func (fs *GitFileInfo) ModTime() time.Time { return fs.modTime }
 func (fs *GitFileInfo) Sys() interface{}   { return nil }
+
+// ID returns SHA of the blob or tree object.
+func (fs *GitFileInfo) ID() string { return fs.id }
@@ -1,6 +1,7 @@
 package pacakimpl
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -23,6 +24,7 @@ import (
 var pullTimeout time.Duration
 var cloneTimeout time.Duration
 var repoWorkingPool = sync.NewExclusivePool()
+var forkPool = sync.NewExclusivePool()
 
 func init() {
 	pullTimeout = time.Minute
@@ -47,6 +49,21 @@ type GitInterface interface {
 	GetRepository(repo string) (PacakRepo, error)
 	ExistsRepository(repo string) bool
 	DeleteRepository(repo string) error
+	Fork(committer git.Signature, src, dst string) error
+	ImportRepository(ctx context.Context, committer git.Signature, repo, src string) error
+	InitMirror(repo string, c MirrorConfig) error
+	InitRepositoryFromTemplate(committer git.Signature, repo, template string, vars map[string]string) error
+	Templates() ([]string, error)
+	SetTemplatesPath(dir string)
+	ListRepositories() ([]string, error)
+	OnRefUpdate(listener RefUpdateListener)
+	SetValidationRules(rules []ValidationRule) error
+	ValidationRules() []ValidationRule
+	SetQuotas(quotas Quotas) error
+	Quotas() Quotas
+	Usage(repo string) (*Usage, error)
+	NamespaceUsage(ns string) (*NamespaceUsage, error)
+	NamespaceUsages() ([]NamespaceUsage, error)
 }
 
 type PacakRepo interface {
@@ -65,24 +82,75 @@ type PacakRepo interface {
 	ListFilesAtRev(rev string) ([]os.FileInfo, error)
 	StatFileAtRev(rev string, path string) (os.FileInfo, error)
 	GetBranches() ([]string, error)
+	Merge(source, target string, strategy MergeStrategy, committer git.Signature, message string) (string, error)
+	Revert(committer git.Signature, branch, commit string) (string, error)
+	CherryPick(committer git.Signature, commits []string, targetBranch string) (string, error)
+	ResetBranch(committer git.Signature, branch, commit string) (string, error)
+	RefLog(ref string, limit int) ([]RefUpdate, error)
+	UndoRefUpdate(committer git.Signature, id int64) (string, error)
+	ApplyPatch(committer git.Signature, branch string, patch []byte, message string) (string, error)
+	WritePatch(w io.Writer, base, head string, format PatchFormat) error
+	WriteBundle(w io.Writer, since []string) (map[string]string, error)
+	Blame(rev, path string, from, to int) ([]BlameLine, error)
+	FileHistory(rev, path string, limit int) ([]FileVersion, error)
+	Grep(rev string, opts GrepOptions) (*GrepResult, error)
+	Refs() (map[string]string, error)
+	LogChanges(rev string, exclude ...string) ([]CommitChanges, error)
+	Protection() (*ProtectionRules, error)
+	SetProtection(rules ProtectionRules) error
+	Mirror() (*MirrorConfig, error)
+	SetMirror(c *MirrorConfig) error
+	MirrorStatus() (*MirrorStatus, error)
+	SyncMirror(ctx context.Context, committer git.Signature) (*MirrorStatus, error)
+	PushMirrors() ([]PushMirror, error)
+	AddPushMirror(url string) (*PushMirror, error)
+	DeletePushMirror(id string) error
+	SetPushMirrorsPending() ([]string, error)
+	SyncPushMirror(ctx context.Context, id string) (*PushMirror, error)
+	ObjectStats() (*ObjectStats, error)
+	MaintenanceStatus() (*MaintenanceStatus, error)
+	Maintain(ctx context.Context, tasks []string) (*MaintenanceStatus, error)
+	CreateMergeRequest(author git.Signature, source, target, title, description string) (*MergeRequest, error)
+	MergeRequests(state string) ([]MergeRequest, error)
+	GetMergeRequest(id int64) (*MergeRequest, error)
+	MergeRequestStatus(id int64) (*MergeRequestStatus, error)
+	WriteMergeRequestDiff(w io.Writer, id int64) error
+	CommentMergeRequest(author git.Signature, id int64, body string) (*MergeRequest, error)
+	ApproveMergeRequest(author git.Signature, id int64) (*MergeRequest, error)
+	CloseMergeRequest(author git.Signature, id int64) (*MergeRequest, error)
+	MergeMergeRequest(committer git.Signature, id int64, strategy MergeStrategy, message string) (*MergeRequest, error)
 	//GetTreeAtRev(rev string) ([]GitFile, error)
 }
 
 type pacakRepo struct {
-	R         *git.Repository
-	LocalPath string
+	R          *git.Repository
+	LocalPath  string
+	name       string
+	listeners  *refListeners
+	validation *validationRules
+	quota      *quotaState
 }
 
 type gitInterface struct {
-	gitRoot   string
-	localRoot string
+	gitRoot    string
+	localRoot  string
+	listeners  *refListeners
+	validation *validationRules
+	templates  *templateConfig
+	quota      *quotaState
 }
 
 func NewGitInterface(gitRoot, localRoot string) GitInterface {
-	return &gitInterface{
-		gitRoot:   gitRoot,
-		localRoot: localRoot,
+	g := &gitInterface{
+		gitRoot:    gitRoot,
+		localRoot:  localRoot,
+		listeners:  &refListeners{},
+		validation: &validationRules{},
+		templates:  &templateConfig{},
+		quota:      newQuotaState(gitRoot, localRoot),
 	}
+	g.listeners.add(g.quota.invalidate)
+	return g
 }
 func (g gitInterface) path(repo ...string) string {
 	return path.Join(append([]string{g.gitRoot}, repo...)...)
@@ -91,6 +159,9 @@ func (g gitInterface) ExistsRepository(repo string) bool {
 	return util.IsExist(g.path(repo))
 }
 func (g gitInterface) DeleteRepository(repo string) error {
+	if err := g.dissociateForks(repo); err != nil {
+		return fmt.Errorf("DeleteRepository: failed dissociate forks - %v", err)
+	}
 	err := os.RemoveAll(path.Join(g.localRoot, repo))
 	if err != nil {
 		return nil
@@ -107,11 +178,23 @@ func (g gitInterface) GetRepository(repo string) (PacakRepo, error) {
 		return nil, fmt.Errorf("OpenRepository: %v", err)
 	}
 	return &pacakRepo{
-		R:         r,
-		LocalPath: path.Join(g.localRoot, repo),
+		R:          r,
+		LocalPath:  path.Join(g.localRoot, repo),
+		name:       repo,
+		listeners:  g.listeners,
+		validation: g.validation,
+		quota:      g.quota,
 	}, nil
 }
 func (g gitInterface) InitRepository(committer git.Signature, repo string, files []GitFile) error {
+	if err := g.quota.check(repo, filesSize(files)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !validFilePath(f.Path) {
+			return fmt.Errorf("InitRepository: invalid file path '%s'", f.Path)
+		}
+	}
 	repoPath := g.path(repo)
 	if err := git.InitRepository(repoPath, true); err != nil {
 		return fmt.Errorf("InitRepository: %v", err)
@@ -133,17 +216,27 @@ func (g gitInterface) InitRepository(committer git.Signature, repo string, files
 			dir = path.Join(tmpDir, dir)
 			os.MkdirAll(dir, os.ModePerm)
 		}
-		filePath := path.Join(tmpDir, f.Path)
-		if err := ioutil.WriteFile(filePath, f.Data, 0666); err != nil {
-			return fmt.Errorf("WriteFile: failed write file - %v", err)
+		if err := writeGitFile(path.Join(tmpDir, f.Path), f); err != nil {
+			return err
 		}
 	}
-	f, err := os.Create(path.Join(tmpDir, ".gitignore"))
+	if !util.IsExist(path.Join(tmpDir, ".gitignore")) {
+		f, err := os.Create(path.Join(tmpDir, ".gitignore"))
+		if err != nil {
+			return fmt.Errorf("InitRepository: failed create init directory - %v", err)
+		}
+		f.Close()
+	}
+	if err := initRepoCommit(tmpDir, &committer); err != nil {
+		return err
+	}
+	r, err := g.GetRepository(repo)
 	if err != nil {
-		return fmt.Errorf("InitRepository: failed create init directory - %v", err)
+		return err
 	}
-	defer f.Close()
-	return initRepoCommit(tmpDir, &committer)
+	p := r.(*pacakRepo)
+	p.recordRefUpdate(git.BRANCH_PREFIX+"master", "", p.refID(git.BRANCH_PREFIX+"master"), "init", &committer)
+	return nil
 }
 
 func initRepoCommit(tmpPath string, sig *git.Signature) (err error) {
@@ -227,7 +320,8 @@ func (p *pacakRepo) GetFileDataAtRev(rev, path string) ([]byte, error) {
 }
 
 func (p *pacakRepo) ListFilesAtRev(rev string) ([]os.FileInfo, error) {
-	output, err := git.NewCommand("ls-tree", "-r", "-t", "-l", rev).RunInDir(p.LocalPath)
+	// Read from the bare repository: local copy may be missing or behind.
+	output, err := git.NewCommand("ls-tree", "-r", "-t", "-l", rev).RunInDir(p.R.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -276,15 +370,15 @@ func (p *pacakRepo) StatFileAtRev(rev string, path string) (os.FileInfo, error)
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimSuffix(path, "/")
 
-	output, err := git.NewCommand("ls-tree", "-l", rev, path).RunInDir(p.LocalPath)
+	output, err := git.NewCommand("ls-tree", "-l", rev, path).RunInDir(p.R.Path)
 	if err != nil {
 		return nil, err
 	}
 
 	lines := strings.Split(output, "\n")
 
-	if len(lines) < 1 {
-		return nil, fmt.Errorf("No files for revison %v and path %v.", rev, path)
+	if strings.TrimSpace(output) == "" {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
 	}
 	// TODO: clarify modtime
 	// Use git log -1 --format="%ad" -- path/to/file
@@ -313,6 +407,7 @@ func (p *pacakRepo) parseFileInfo(line string, modtime time.Time) (os.FileInfo,
 	}
 	name := "/" + strings.Join(fields[4:], " ")
 	return &GitFileInfo{
+		id:      fields[2],
 		size:    size,
 		dir:     mode == os.ModePerm,
 		mode:    mode,
@@ -366,6 +461,18 @@ func (p *pacakRepo) TagList() ([]string, error) {
 }
 
 func (p *pacakRepo) DeleteTag(tag string) error {
+	old := p.refID(git.TAG_PREFIX + tag)
+	if err := p.checkRefUpdate(git.TAG_PREFIX+tag, old, "", "delete-tag"); err != nil {
+		return err
+	}
+	if err := p.deleteTag(tag); err != nil {
+		return err
+	}
+	p.recordRefUpdate(git.TAG_PREFIX+tag, old, "", "delete-tag", nil)
+	return nil
+}
+
+func (p *pacakRepo) deleteTag(tag string) error {
 	// delete tag locally
 	cmd := git.NewCommand("tag", "-d", tag)
 	_, err := cmd.RunInDir(p.LocalPath)
@@ -378,8 +485,14 @@ func (p *pacakRepo) DeleteTag(tag string) error {
 }
 
 func (p *pacakRepo) PushTag(tag string, fromRef string, override bool) error {
+	old := p.refID(git.TAG_PREFIX + tag)
+	if override && old != "" {
+		if err := p.checkRefUpdate(git.TAG_PREFIX+tag, old, p.refID(fromRef), "push-tag"); err != nil {
+			return err
+		}
+	}
 	if override && p.R.IsTagExist(tag) {
-		if err := p.DeleteTag(tag); err != nil {
+		if err := p.deleteTag(tag); err != nil {
 			return err
 		}
 	}
@@ -392,27 +505,23 @@ func (p *pacakRepo) PushTag(tag string, fromRef string, override bool) error {
 	}
 
 	// Push a new tag.
-	return git.Push(p.LocalPath, "origin", tag)
+	if err := git.Push(p.LocalPath, "origin", tag); err != nil {
+		return err
+	}
+	p.recordRefUpdate(git.TAG_PREFIX+tag, old, p.refID(git.TAG_PREFIX+tag), "push-tag", nil)
+	return nil
 }
 
 func (p *pacakRepo) CheckoutAndSave(committer git.Signature, message string, revision, newBranch string, files []GitFile) (string, error) {
-	repoWorkingPool.CheckIn(p.R.Path)
+	defer p.lockLocalCopy()()
 	repoPath := p.R.Path
 	localPath := p.LocalPath
-	defer func() {
-		if lockFile := path.Join(localPath, ".git", "index.lock"); util.IsExist(lockFile) {
-			logrus.Errorln("index lock exists. remove it.")
-			os.Remove(lockFile)
-
-		}
-		repoWorkingPool.CheckOut(p.R.Path)
-	}()
 	if err := git.ResetHEAD(p.LocalPath, true, "master"); err != nil {
 		return "", fmt.Errorf("git reset --hard master: %v", err)
 	}
 	// Directly return error if new branch already exists in the server
 	if git.IsBranchExist(repoPath, newBranch) {
-		return "", errors.BranchAlreadyExists{newBranch}
+		return "", errors.BranchAlreadyExists{Name: newBranch}
 	}
 	// Otherwise, delete branch from local copy in case out of sync
 	if git.IsBranchExist(localPath, newBranch) {
@@ -429,18 +538,55 @@ func (p *pacakRepo) CheckoutAndSave(committer git.Signature, message string, rev
 		return "", fmt.Errorf("CheckoutNewBranch [new_branch: %s]: %v", newBranch, err)
 	}
 
-	return save(p.R, localPath, committer, message, newBranch, files)
+	return p.save("checkout-and-save", committer, message, newBranch, files)
+}
+func (p *pacakRepo) save(action string, committer git.Signature, message string, newBranch string, files []GitFile) (string, error) {
+	if err := p.quota.check(p.name, filesSize(files)); err != nil {
+		return "", err
+	}
+	old := p.refID(git.BRANCH_PREFIX + newBranch)
+	commit, err := save(p.R, p.LocalPath, committer, message, newBranch, files, func() error {
+		return p.beforePush(newBranch, action)
+	})
+	if err != nil {
+		return "", err
+	}
+	p.recordRefUpdate(git.BRANCH_PREFIX+newBranch, old, commit, action, &committer)
+	return commit, nil
+}
+
+// writeGitFile writes data of f to filePath setting its executable bit.
+func writeGitFile(filePath string, f GitFile) error {
+	mode := os.FileMode(0666)
+	if f.Executable {
+		mode = 0777
+	}
+	if err := ioutil.WriteFile(filePath, f.Data, mode); err != nil {
+		return fmt.Errorf("WriteFile: failed write file - %v", err)
+	}
+	// WriteFile keeps mode of existing file.
+	if err := os.Chmod(filePath, mode); err != nil {
+		return fmt.Errorf("Chmod: failed change file mode - %v", err)
+	}
+	return nil
 }
-func save(repo *git.Repository, localPath string, committer git.Signature, message string, newBranch string, files []GitFile) (string, error) {
+
+// save commits files to the local copy and pushes newBranch once beforePush succeeds.
+func save(repo *git.Repository, localPath string, committer git.Signature, message string, newBranch string, files []GitFile, beforePush func() error) (string, error) {
 	for _, f := range files {
+		if f.Delete {
+			if err := os.RemoveAll(path.Join(localPath, f.Path)); err != nil {
+				return "", fmt.Errorf("RemoveAll: failed remove file - %v", err)
+			}
+			continue
+		}
 		dir := path.Dir(f.Path)
 		if dir != "" {
 			dir = path.Join(localPath, dir)
 			os.MkdirAll(dir, os.ModePerm)
 		}
-		filePath := path.Join(localPath, f.Path)
-		if err := ioutil.WriteFile(filePath, f.Data, 0666); err != nil {
-			return "", fmt.Errorf("WriteFile: failed write file - %v", err)
+		if err := writeGitFile(path.Join(localPath, f.Path), f); err != nil {
+			return "", err
 		}
 	}
 
@@ -451,6 +597,8 @@ func save(repo *git.Repository, localPath string, committer git.Signature, messa
 		Message:   message,
 	}); err != nil {
 		return "", fmt.Errorf("CommitChanges: %v", err)
+	} else if err = beforePush(); err != nil {
+		return "", err
 	} else if err = git.Push(localPath, "origin", newBranch); err != nil {
 		return "", fmt.Errorf("git push origin %s: %v", newBranch, err)
 	}
@@ -461,17 +609,9 @@ func save(repo *git.Repository, localPath string, committer git.Signature, messa
 	return commit.ID.String(), nil
 }
 func (p *pacakRepo) Save(committer git.Signature, message string, oldBrach, newBranch string, files []GitFile) (string, error) {
-	repoWorkingPool.CheckIn(p.R.Path)
+	defer p.lockLocalCopy()()
 	repoPath := p.R.Path
 	localPath := p.LocalPath
-	defer func() {
-		if lockFile := path.Join(localPath, ".git", "index.lock"); util.IsExist(lockFile) {
-			logrus.Errorln("index lock exists. remove it.")
-			os.Remove(lockFile)
-
-		}
-		repoWorkingPool.CheckOut(p.R.Path)
-	}()
 	if oldBrach == newBranch && newBranch != "" && newBranch != "master" {
 		if !git.IsBranchExist(repoPath, newBranch) {
 			oldBrach = "master"
@@ -485,7 +625,7 @@ func (p *pacakRepo) Save(committer git.Signature, message string, oldBrach, newB
 	if oldBrach != newBranch {
 		// Directly return error if new branch already exists in the server
 		if git.IsBranchExist(repoPath, newBranch) {
-			return "", errors.BranchAlreadyExists{newBranch}
+			return "", errors.BranchAlreadyExists{Name: newBranch}
 		}
 
 		// Otherwise, delete branch from local copy in case out of sync
@@ -501,20 +641,12 @@ func (p *pacakRepo) Save(committer git.Signature, message string, oldBrach, newB
 			return "", fmt.Errorf("CheckoutNewBranch [old_branch: %s, new_branch: %s]: %v", oldBrach, newBranch, err)
 		}
 	}
-	return save(p.R, localPath, committer, message, newBranch, files)
+	return p.save("save", committer, message, newBranch, files)
 }
 
 func (p *pacakRepo) CleanPush(committer git.Signature, message string, branch string) (string, error) {
-	repoWorkingPool.CheckIn(p.R.Path)
+	defer p.lockLocalCopy()()
 	localPath := p.LocalPath
-	defer func() {
-		if lockFile := path.Join(localPath, ".git", "index.lock"); util.IsExist(lockFile) {
-			logrus.Errorln("index lock exists. remove it.")
-			os.Remove(lockFile)
-
-		}
-		repoWorkingPool.CheckOut(p.R.Path)
-	}()
 	if err := p.DiscardLocalRepoBranchChanges(branch); err != nil {
 		return "", fmt.Errorf("DiscardLocalRepoBranchChanges [branch: %s]: %v", branch, err)
 	} else if err = p.UpdateLocalCopyBranch(branch); err != nil {
@@ -527,7 +659,21 @@ func (p *pacakRepo) CleanPush(committer git.Signature, message string, branch st
 		return "", fmt.Errorf("%v: %v", cmd, err)
 	}
 
-	return save(p.R, localPath, committer, message, branch, []GitFile{})
+	return p.save("clean-push", committer, message, branch, []GitFile{})
+}
+
+// lockLocalCopy checks in repository to repoWorkingPool and returns function
+// which checks it out. Stale index lock of the local copy is removed on check out.
+func (p *pacakRepo) lockLocalCopy() func() {
+	repoWorkingPool.CheckIn(p.R.Path)
+	return func() {
+		if lockFile := path.Join(p.LocalPath, ".git", "index.lock"); util.IsExist(lockFile) {
+			logrus.Errorln("index lock exists. remove it.")
+			os.Remove(lockFile)
+
+		}
+		repoWorkingPool.CheckOut(p.R.Path)
+	}
 }
 
 func (p *pacakRepo) DiscardLocalRepoBranchChanges(branch string) error {
@@ -0,0 +1,287 @@
+package pacakimpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	gosync "sync"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/errors"
+	"github.com/kuberlab/pacak/pkg/process"
+	"gopkg.in/yaml.v2"
+)
+
+// maxViolationMessage limits length of validator output put to a violation.
+const maxViolationMessage = 4096
+
+// ValidationRule configures checks of changes before they are pushed to
+// matching repositories. All checks of all matching rules must pass.
+type ValidationRule struct {
+	// Repo is a repository name or a namespace ending with '/'. Empty matches all repositories.
+	Repo string `json:"repo,omitempty"`
+	// Branches are branch name patterns like 'release-*'. Empty matches all branches.
+	Branches []string `json:"branches,omitempty"`
+	// MaxFileSize rejects changed files larger than given number of bytes.
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// ForbiddenPaths rejects changed files matching any of the patterns.
+	// Pattern without '/' is matched against file name, pattern ending
+	// with '/' matches everything in the directory.
+	ForbiddenPaths []string `json:"forbiddenPaths,omitempty"`
+	// RequiredFiles must exist in the resulting tree.
+	RequiredFiles []string `json:"requiredFiles,omitempty"`
+	// CheckSyntax rejects changed .json, .yaml and .yml files which can not be parsed.
+	CheckSyntax bool `json:"checkSyntax,omitempty"`
+	// Commands are run in the resulting tree, non-zero exit status rejects changes.
+	Commands []ValidationCommand `json:"commands,omitempty"`
+}
+
+// ValidationCommand is an external validator. It is run in the directory
+// with the resulting tree with Args followed by repository name, branch,
+// old and new commit IDs. Its output is reported as the violation message.
+type ValidationCommand struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Timeout in seconds, pkg/process default is used if zero.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (r *ValidationRule) matches(repo, branch string) bool {
+	if r.Repo != "" && r.Repo != repo && !(strings.HasSuffix(r.Repo, "/") && strings.HasPrefix(repo, r.Repo)) {
+		return false
+	}
+	if len(r.Branches) == 0 {
+		return true
+	}
+	for _, b := range r.Branches {
+		if ok, _ := path.Match(b, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ValidationRule) validate() error {
+	for _, patterns := range [][]string{r.Branches, r.ForbiddenPaths} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern '%s'", p)
+			}
+		}
+	}
+	for _, c := range r.Commands {
+		if c.Command == "" {
+			return fmt.Errorf("command of validator '%s' is empty", c.Name)
+		}
+	}
+	return nil
+}
+
+// LoadValidationRules reads validation rules from JSON file.
+func LoadValidationRules(filePath string) ([]ValidationRule, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	rules := []ValidationRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("Failed parse validation rules - %v", err)
+	}
+	return rules, nil
+}
+
+type validationRules struct {
+	lock  gosync.RWMutex
+	rules []ValidationRule
+}
+
+func (v *validationRules) matching(repo, branch string) []ValidationRule {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	res := []ValidationRule{}
+	for _, r := range v.rules {
+		if r.matches(repo, branch) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// SetValidationRules replaces rules changes are validated with before push.
+func (g gitInterface) SetValidationRules(rules []ValidationRule) error {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return err
+		}
+	}
+	g.validation.lock.Lock()
+	defer g.validation.lock.Unlock()
+	g.validation.rules = rules
+	return nil
+}
+
+func (g gitInterface) ValidationRules() []ValidationRule {
+	g.validation.lock.RLock()
+	defer g.validation.lock.RUnlock()
+	return append([]ValidationRule{}, g.validation.rules...)
+}
+
+// validatePush checks changes of head which is about to be pushed to branch
+// pointing to old. It returns errors.ValidationFailed if any rule is broken.
+func (p *pacakRepo) validatePush(branch, old, head string) error {
+	rules := p.validation.matching(p.name, branch)
+	if len(rules) == 0 {
+		return nil
+	}
+	// New branch is validated against the commit it was created from.
+	base := old
+	if base == "" {
+		base = emptyTreeID
+		if output, err := git.NewCommand("rev-parse", "--verify", "-q", head+"^").RunInDir(p.LocalPath); err == nil {
+			base = strings.TrimSpace(output)
+		}
+	}
+	output, err := git.NewCommand("diff", "--name-only", "-z", "--no-renames", "--diff-filter=d", base, head).RunInDir(p.LocalPath)
+	if err != nil {
+		return fmt.Errorf("git diff %s %s: %v", base, head, err)
+	}
+	changed := []string{}
+	for _, f := range strings.Split(output, "\x00") {
+		if f != "" {
+			changed = append(changed, f)
+		}
+	}
+
+	violations := []errors.Violation{}
+	for _, r := range rules {
+		violations = append(violations, p.checkRule(r, branch, old, head, changed)...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.ValidationFailed{Branch: branch, Violations: violations}
+}
+
+func (p *pacakRepo) checkRule(r ValidationRule, branch, old, head string, changed []string) []errors.Violation {
+	violations := []errors.Violation{}
+	for _, f := range changed {
+		if forbiddenPath(r.ForbiddenPaths, f) {
+			violations = append(violations, errors.Violation{
+				Rule:    "forbiddenPaths",
+				Path:    f,
+				Message: "path is forbidden",
+			})
+			continue
+		}
+		fi, err := os.Lstat(path.Join(p.LocalPath, f))
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if r.MaxFileSize > 0 && fi.Size() > r.MaxFileSize {
+			violations = append(violations, errors.Violation{
+				Rule:    "maxFileSize",
+				Path:    f,
+				Message: fmt.Sprintf("file size %d exceeds %d bytes", fi.Size(), r.MaxFileSize),
+			})
+			continue
+		}
+		if r.CheckSyntax {
+			if err := checkSyntax(path.Join(p.LocalPath, f)); err != nil {
+				violations = append(violations, errors.Violation{
+					Rule:    "syntax",
+					Path:    f,
+					Message: err.Error(),
+				})
+			}
+		}
+	}
+	for _, f := range r.RequiredFiles {
+		if _, err := os.Stat(path.Join(p.LocalPath, f)); err != nil {
+			violations = append(violations, errors.Violation{
+				Rule:    "requiredFiles",
+				Path:    f,
+				Message: "required file is missing",
+			})
+		}
+	}
+	for _, c := range r.Commands {
+		timeout := time.Duration(-1)
+		if c.Timeout > 0 {
+			timeout = time.Duration(c.Timeout) * time.Second
+		}
+		args := append(append([]string{}, c.Args...), p.name, branch, old, head)
+		stdout, stderr, err := process.ExecDir(
+			timeout, p.LocalPath, fmt.Sprintf("validate(%s): %s:%s", c.Name, p.name, branch), c.Command, args...,
+		)
+		if err == nil {
+			continue
+		}
+		message := strings.TrimSpace(stdout + "\n" + stderr)
+		if message == "" {
+			message = err.Error()
+		}
+		if len(message) > maxViolationMessage {
+			message = message[:maxViolationMessage] + "..."
+		}
+		violations = append(violations, errors.Violation{
+			Rule:    "command:" + c.Name,
+			Message: message,
+		})
+	}
+	return violations
+}
+
+func forbiddenPath(patterns []string, f string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case strings.HasSuffix(pattern, "/"):
+			if strings.HasPrefix(f, pattern) || strings.Contains(f, "/"+pattern) {
+				return true
+			}
+		case !strings.Contains(pattern, "/"):
+			if ok, _ := path.Match(pattern, path.Base(f)); ok {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), f); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkSyntax parses JSON and YAML files.
+func checkSyntax(filePath string) error {
+	ext := strings.ToLower(path.Ext(filePath))
+	if ext != ".json" && ext != ".yaml" && ext != ".yml" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if ext == ".json" {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid JSON: %v", err)
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid YAML: %v", err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package process
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
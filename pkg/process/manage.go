@@ -6,6 +6,7 @@ package process
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os/exec"
@@ -27,6 +28,8 @@ type Process struct {
 	Description string
 	Start       time.Time
 	Cmd         *exec.Cmd
+	// JobID is the job process is started by, empty if none.
+	JobID string
 }
 
 type pidCounter struct {
@@ -46,6 +49,10 @@ var Processes []*Process
 
 // Add adds a process to global list and returns its PID.
 func Add(desc string, cmd *exec.Cmd) int64 {
+	return addJob("", desc, cmd)
+}
+
+func addJob(jobID, desc string, cmd *exec.Cmd) int64 {
 	counter.Lock()
 	defer counter.Unlock()
 
@@ -55,10 +62,38 @@ func Add(desc string, cmd *exec.Cmd) int64 {
 		Description: desc,
 		Start:       time.Now(),
 		Cmd:         cmd,
+		JobID:       jobID,
 	})
 	return pid
 }
 
+// List returns copies of processes started by the job, or all processes if jobID is empty.
+func List(jobID string) []Process {
+	counter.Lock()
+	defer counter.Unlock()
+
+	res := []Process{}
+	for _, p := range Processes {
+		if jobID == "" || p.JobID == jobID {
+			res = append(res, *p)
+		}
+	}
+	return res
+}
+
+type jobKey struct{}
+
+// WithJob returns context processes started with are attributed to the job.
+func WithJob(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobKey{}, jobID)
+}
+
+// JobID returns ID of the job ctx belongs to.
+func JobID(ctx context.Context) string {
+	id, _ := ctx.Value(jobKey{}).(string)
+	return id
+}
+
 // Remove removes a process from global list.
 // It returns true if the process is found and removed by given pid.
 func Remove(pid int64) bool {
@@ -76,6 +111,12 @@ func Remove(pid int64) bool {
 
 // Exec starts executing a shell command in given path, it tracks corresponding process and timeout.
 func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (string, string, error) {
+	return ExecDirContext(context.Background(), timeout, dir, desc, cmdName, args...)
+}
+
+// ExecDirContext is like ExecDir but also kills the process when ctx is done.
+// The process is attributed to the job of ctx.
+func ExecDirContext(ctx context.Context, timeout time.Duration, dir, desc, cmdName string, args ...string) (string, string, error) {
 	if timeout == -1 {
 		timeout = DEFAULT_TIMEOUT
 	}
@@ -87,11 +128,12 @@ func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (
 	cmd.Dir = dir
 	cmd.Stdout = bufOut
 	cmd.Stderr = bufErr
+	setProcessGroup(cmd)
 	if err := cmd.Start(); err != nil {
 		return "", err.Error(), err
 	}
 
-	pid := Add(desc, cmd)
+	pid := addJob(JobID(ctx), desc, cmd)
 	done := make(chan error)
 	go func() {
 		done <- cmd.Wait()
@@ -105,6 +147,12 @@ func ExecDir(timeout time.Duration, dir, desc, cmdName string, args ...string) (
 		}
 		<-done
 		return "", ErrExecTimeout.Error(), ErrExecTimeout
+	case <-ctx.Done():
+		if errKill := Kill(pid); errKill != nil {
+			logrus.Errorf("Fail to kill cancelled process [pid: %d, desc: %s]: %v", pid, desc, errKill)
+		}
+		<-done
+		return "", ctx.Err().Error(), ctx.Err()
 	case err = <-done:
 	}
 
@@ -126,9 +174,10 @@ func Exec(desc, cmdName string, args ...string) (string, string, error) {
 func Kill(pid int64) error {
 	for _, proc := range Processes {
 		if proc.PID == pid {
+			// ProcessState is set once the process has been waited for.
 			if proc.Cmd != nil && proc.Cmd.Process != nil &&
-				proc.Cmd.ProcessState != nil && !proc.Cmd.ProcessState.Exited() {
-				if err := proc.Cmd.Process.Kill(); err != nil {
+				(proc.Cmd.ProcessState == nil || !proc.Cmd.ProcessState.Exited()) {
+				if err := killProcess(proc.Cmd); err != nil {
 					return fmt.Errorf("fail to kill process [pid: %d, desc: %s]: %v", proc.PID, proc.Description, err)
 				}
 			}
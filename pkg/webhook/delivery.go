@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxAttempts is the number of attempts after which delivery fails.
+	maxAttempts = 10
+	// retryDelay is the delay before the second attempt, doubled on every next one.
+	retryDelay    = 10 * time.Second
+	maxRetryDelay = time.Hour
+	// logSize is the number of finished deliveries kept per hook.
+	logSize = 100
+	// maxSending limits number of deliveries sent at once.
+	maxSending = 8
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Delivery is a payload sent (or to be sent) to a hook.
+type Delivery struct {
+	ID          string          `json:"id"`
+	HookID      string          `json:"hookId"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt,omitempty"`
+	// ResponseStatus is HTTP status of the last attempt, zero if request failed.
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	LastError      string    `json:"lastError,omitempty"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+}
+
+// enqueue stores a new delivery of payload to the hook. Must be called with lock held.
+func (m *Manager) enqueue(h *Hook, p *Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	d := &Delivery{
+		ID:          fmt.Sprintf("%x-%s", now.UnixNano(), newID()),
+		HookID:      h.ID,
+		Event:       p.Event,
+		Payload:     data,
+		Status:      StatusPending,
+		NextAttempt: now,
+		Created:     now,
+		Updated:     now,
+	}
+	if err := writeJSON(m.path("queue", d.ID+".json"), d); err != nil {
+		return err
+	}
+	m.queue[d.ID] = d
+	return nil
+}
+
+// Deliveries returns pending and finished deliveries of the hook, newest first.
+func (m *Manager) Deliveries(hookID string) []Delivery {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := []Delivery{}
+	for _, d := range m.queue {
+		if d.HookID == hookID {
+			res = append(res, *d)
+		}
+	}
+	for _, d := range m.log[hookID] {
+		res = append(res, *d)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.After(res[j].Created) })
+	return res
+}
+
+func (m *Manager) run() {
+	for range time.Tick(time.Second) {
+		now := time.Now()
+		m.lock.Lock()
+		due := []*Delivery{}
+		for _, d := range m.queue {
+			if !m.sending[d.ID] && !d.NextAttempt.After(now) {
+				due = append(due, d)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].Created.Before(due[j].Created) })
+		for _, d := range due {
+			if len(m.sending) >= maxSending {
+				break
+			}
+			h, ok := m.hooks[d.HookID]
+			if !ok {
+				continue
+			}
+			m.sending[d.ID] = true
+			go m.deliver(*h, d.ID, d.Event, d.Payload)
+		}
+		m.lock.Unlock()
+	}
+}
+
+// deliver sends the payload and updates delivery state with the result.
+func (m *Manager) deliver(h Hook, id, event string, payload []byte) {
+	status, err := send(h, id, event, payload)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sending, id)
+	d, ok := m.queue[id]
+	if !ok {
+		// Hook was deleted meanwhile.
+		return
+	}
+	d.Attempts++
+	d.ResponseStatus = status
+	d.LastError = ""
+	d.Updated = time.Now()
+	switch {
+	case err == nil:
+		d.Status = StatusDelivered
+	case d.Attempts >= maxAttempts:
+		d.Status = StatusFailed
+		d.LastError = err.Error()
+	default:
+		d.LastError = err.Error()
+		delay := retryDelay << uint(d.Attempts-1)
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		d.NextAttempt = d.Updated.Add(delay)
+		if err := writeJSON(m.path("queue", d.ID+".json"), d); err != nil {
+			logrus.Errorf("Webhook: failed save delivery %v: %v", d.ID, err)
+		}
+		return
+	}
+	if d.Status == StatusFailed {
+		logrus.Errorf("Webhook: delivery %v to %v failed after %v attempts: %v", d.ID, h.URL, d.Attempts, d.LastError)
+	}
+	d.NextAttempt = time.Time{}
+	delete(m.queue, id)
+	log := append(m.log[h.ID], d)
+	if len(log) > logSize {
+		log = log[len(log)-logSize:]
+	}
+	m.log[h.ID] = log
+	if err := writeJSON(m.path("log", h.ID+".json"), log); err != nil {
+		logrus.Errorf("Webhook: failed save delivery log of %v: %v", h.ID, err)
+	}
+	os.Remove(m.path("queue", id+".json"))
+}
+
+// Sign returns value of X-Pacak-Signature header for the payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts payload to the hook URL. Any non-2xx response is an error.
+func send(h Hook, id, event string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pacak-webhook")
+	req.Header.Set("X-Pacak-Event", event)
+	req.Header.Set("X-Pacak-Delivery", id)
+	if h.Secret != "" {
+		req.Header.Set("X-Pacak-Signature", Sign(h.Secret, payload))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %v", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
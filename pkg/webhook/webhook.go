@@ -0,0 +1,344 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/gogits/git-module"
+	"github.com/kuberlab/pacak/pkg/pacakimpl"
+	"github.com/sirupsen/logrus"
+)
+
+// Events a hook can subscribe to.
+const (
+	EventInit         = "repository.init"
+	EventBranchPush   = "branch.push"
+	EventBranchDelete = "branch.delete"
+	EventTagPush      = "tag.push"
+	EventTagDelete    = "tag.delete"
+)
+
+var events = map[string]bool{
+	EventInit:         true,
+	EventBranchPush:   true,
+	EventBranchDelete: true,
+	EventTagPush:      true,
+	EventTagDelete:    true,
+}
+
+// Hook is a webhook subscription.
+type Hook struct {
+	ID string `json:"id"`
+	// Repo is a repository name or a namespace ending with '/'.
+	Repo string `json:"repo"`
+	URL  string `json:"url"`
+	// Secret is the key of HMAC-SHA256 signature of payloads. It is never returned back.
+	Secret string `json:"secret,omitempty"`
+	// Events filters events hook is called on, all events if empty.
+	Events []string `json:"events,omitempty"`
+	// Refs filters refs by patterns like 'refs/heads/dataset-*', all refs if empty.
+	Refs    []string  `json:"refs,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Payload is the JSON body posted to the hook URL.
+type Payload struct {
+	Event  string    `json:"event"`
+	Repo   string    `json:"repo"`
+	Ref    string    `json:"ref"`
+	Old    string    `json:"old"`
+	New    string    `json:"new"`
+	Action string    `json:"action"`
+	Pusher string    `json:"pusher,omitempty"`
+	When   time.Time `json:"when"`
+}
+
+// Validate checks the hook and normalizes its fields.
+func (h *Hook) Validate() error {
+	if h.Repo == "" {
+		return fmt.Errorf("repo is required")
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url '%s'", h.URL)
+	}
+	for _, e := range h.Events {
+		if !events[e] {
+			return fmt.Errorf("unknown event '%s'", e)
+		}
+	}
+	for _, r := range h.Refs {
+		if _, err := path.Match(r, ""); err != nil {
+			return fmt.Errorf("invalid ref pattern '%s'", r)
+		}
+	}
+	return nil
+}
+
+func (h *Hook) matches(p *Payload) bool {
+	if p.Repo != h.Repo && !(strings.HasSuffix(h.Repo, "/") && strings.HasPrefix(p.Repo, h.Repo)) {
+		return false
+	}
+	if len(h.Events) > 0 && !contains(h.Events, p.Event) {
+		return false
+	}
+	if len(h.Refs) == 0 {
+		return true
+	}
+	for _, r := range h.Refs {
+		if ok, _ := path.Match(r, p.Ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Public returns copy of the hook without secret.
+func (h Hook) Public() Hook {
+	h.Secret = ""
+	return h
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// eventOf returns event of the ref update.
+func eventOf(u pacakimpl.RefUpdate) string {
+	tag := strings.HasPrefix(u.Ref, git.TAG_PREFIX)
+	switch {
+	case u.Action == "init":
+		return EventInit
+	case tag && u.New == "":
+		return EventTagDelete
+	case tag:
+		return EventTagPush
+	case u.New == "":
+		return EventBranchDelete
+	default:
+		return EventBranchPush
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Manager maintains hooks and delivers events to them. Hooks and
+// pending deliveries are stored under dir, so they survive restarts.
+type Manager struct {
+	dir string
+
+	lock  sync.Mutex
+	hooks map[string]*Hook
+	// queue maintains pending deliveries by ID.
+	queue map[string]*Delivery
+	// log maintains finished deliveries per hook, oldest first.
+	log map[string][]*Delivery
+	// sending maintains IDs of deliveries being sent.
+	sending map[string]bool
+}
+
+// NewManager loads hooks and pending deliveries from dir, subscribes
+// to ref updates of git and starts delivering.
+func NewManager(dir string, gitInterface pacakimpl.GitInterface) (*Manager, error) {
+	m := &Manager{
+		dir:     dir,
+		hooks:   make(map[string]*Hook),
+		queue:   make(map[string]*Delivery),
+		log:     make(map[string][]*Delivery),
+		sending: make(map[string]bool),
+	}
+	if err := os.MkdirAll(m.path("queue"), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.path("log"), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	gitInterface.OnRefUpdate(m.RefUpdated)
+	go m.run()
+	return m, nil
+}
+
+func (m *Manager) path(name ...string) string {
+	return path.Join(append([]string{m.dir}, name...)...)
+}
+
+func (m *Manager) load() error {
+	hooks := []*Hook{}
+	if err := readJSON(m.path("hooks.json"), &hooks); err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		m.hooks[h.ID] = h
+	}
+	files, err := ioutil.ReadDir(m.path("queue"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		d := &Delivery{}
+		if err := readJSON(m.path("queue", f.Name()), d); err != nil {
+			logrus.Errorf("Webhook: skip broken delivery %v: %v", f.Name(), err)
+			continue
+		}
+		m.queue[d.ID] = d
+	}
+	for id := range m.hooks {
+		log := []*Delivery{}
+		if err := readJSON(m.path("log", id+".json"), &log); err != nil {
+			return err
+		}
+		m.log[id] = log
+	}
+	return nil
+}
+
+func readJSON(filePath string, v interface{}) error {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(filePath string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// saveHooks writes hooks to disk. Must be called with lock held.
+func (m *Manager) saveHooks() error {
+	hooks := make([]*Hook, 0, len(m.hooks))
+	for _, h := range m.hooks {
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].Created.Before(hooks[j].Created) })
+	return writeJSON(m.path("hooks.json"), hooks)
+}
+
+// List returns hooks of the repository (including hooks of its namespace)
+// or all hooks if repo is empty.
+func (m *Manager) List(repo string) []Hook {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := []Hook{}
+	for _, h := range m.hooks {
+		if repo == "" || h.Repo == repo || (strings.HasSuffix(h.Repo, "/") && strings.HasPrefix(repo, h.Repo)) {
+			res = append(res, h.Public())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.Before(res[j].Created) })
+	return res
+}
+
+// Get returns the hook or nil if it does not exist.
+func (m *Manager) Get(id string) *Hook {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h, ok := m.hooks[id]; ok {
+		res := h.Public()
+		return &res
+	}
+	return nil
+}
+
+// Add validates and stores a new hook.
+func (m *Manager) Add(h Hook) (*Hook, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	h.ID = newID()
+	h.Created = time.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.hooks[h.ID] = &h
+	if err := m.saveHooks(); err != nil {
+		delete(m.hooks, h.ID)
+		return nil, err
+	}
+	res := h.Public()
+	return &res, nil
+}
+
+// Delete removes the hook with its pending deliveries and log.
+// It returns false if the hook does not exist.
+func (m *Manager) Delete(id string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.hooks[id]
+	if !ok {
+		return false, nil
+	}
+	delete(m.hooks, id)
+	if err := m.saveHooks(); err != nil {
+		m.hooks[id] = h
+		return false, err
+	}
+	for _, d := range m.queue {
+		if d.HookID == id {
+			delete(m.queue, d.ID)
+			os.Remove(m.path("queue", d.ID+".json"))
+		}
+	}
+	delete(m.log, id)
+	os.Remove(m.path("log", id+".json"))
+	return true, nil
+}
+
+// RefUpdated queues deliveries of the ref update to all matching hooks.
+func (m *Manager) RefUpdated(repo string, u pacakimpl.RefUpdate) {
+	p := &Payload{
+		Event:  eventOf(u),
+		Repo:   repo,
+		Ref:    u.Ref,
+		Old:    u.Old,
+		New:    u.New,
+		Action: u.Action,
+		Pusher: u.Committer,
+		When:   u.When,
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, h := range m.hooks {
+		if !h.matches(p) {
+			continue
+		}
+		if err := m.enqueue(h, p); err != nil {
+			logrus.Errorf("Webhook: failed queue %v event of %v for %v: %v", p.Event, repo, h.URL, err)
+		}
+	}
+}